@@ -1,41 +1,254 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/lyubomir-bozhinov/pullio/internal/config"
+	"github.com/lyubomir-bozhinov/pullio/internal/events"
 	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
 	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+	"github.com/lyubomir-bozhinov/pullio/internal/metrics"
+	"github.com/lyubomir-bozhinov/pullio/internal/notify"
+	"github.com/lyubomir-bozhinov/pullio/internal/report"
 	"github.com/lyubomir-bozhinov/pullio/internal/sshagent"
+	"github.com/lyubomir-bozhinov/pullio/internal/state"
 	"github.com/lyubomir-bozhinov/pullio/internal/utils"
+	"github.com/lyubomir-bozhinov/pullio/pkg/pullio"
+	"golang.org/x/term"
 )
 
 var (
-	sshKeyFlag     string
-	branchesFlag   string
-	concurrentFlag int
-	verboseFlag    bool
-	startPath      string
+	keyFlag           stringList
+	branchesFlag      string
+	branchesRegexFlag string
+	fallbackFlag      string
+	concurrentFlag    int
+	perHostFlag       int
+	scanParallelFlag  int
+	pullParallelFlag  int
+	verboseFlag       bool
+	quietFlag         bool
+	logLevelFlag      string
+	logFileFlag       string
+	colorFlag         string
+	dryRunFlag        bool
+	stashFlag         bool
+	fetchOnlyFlag     bool
+	startPath         string
+	excludeFlag       stringList
+	matchFlag         stringList
+	maxDepthFlag      int
+	followLinksFlag   bool
+	nestedFlag        bool
+	worktreesFlag     bool
+	skipDirFlag       stringList
+	noDefaultSkipFlag bool
+	skipHiddenFlag    bool
+	outputFlag        string
+	templateFlag      string
+	eventsFlag        string
+	openRequestsFlag  bool
+	confirmFlag       bool
+	confirmAboveFlag  int
+	yesFlag           bool
+	submodulesFlag    bool
+	ffOnlyFlag        bool
+	resetHardFlag     bool
+	resetForceFlag    bool
+	discardAckFlag    bool
+	strictFlag        bool
+	failFastFlag      bool
+	credHelperFlag    string
+	backendFlag       string
+	reportFlag        string
+	listFlag          bool
+	useKeychainFlag   bool
+	timeoutFlag       time.Duration
+	maxRuntimeFlag    time.Duration
+	waitFlag          bool
+	watchFlag         time.Duration
+	rescanFlag        time.Duration
+	metricsFileFlag   string
+	reposFromFlag     string
+	remoteFilterFlag  string
+	remoteFlag        string
+	pruneFlag         bool
+	gcFlag            bool
+	gcIntervalFlag    string
+	unshallowFlag     bool
+	verifyCleanFlag   string
+	conflictsFlag     bool
+	statsFlag         bool
+	remoteNameFlag    bool
+	forceBranchFlag   bool
+	currentBranchFlag bool
+	allBranchesFlag   bool
+	sinceFlag         string
+	maxReposFlag      int
+	maxFoundFlag      int
+	tagsFlag          bool
+	noTagsFlag        bool
+	gitConfigFlag     settingList
+	preHookFlag       string
+	postHookFlag      string
+	ignoreHookErrFlag bool
+	notifyFlag        bool
+	stateFileFlag     string
+	onlyFailedFlag    bool
+	
+	// defaultSSHKeyPath is used when no -key is given.
+	defaultSSHKeyPath string
+	
+	// explicitFlags holds the flags given on the command line, which take
+	// precedence over environment variables and config files.
+	explicitFlags = make(map[string]bool)
+	
+	// summaryTemplate is parsed from -template, when it is given.
+	summaryTemplate *template.Template
 )
 
-func init() {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		homeDir = "~"
+// stringList is a flag.Value for options that can be repeated or given as a
+// comma-separated list.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
+	}
+	return nil
+}
+
+func (s *stringList) reset() {
+	*s = nil
+}
+
+// settingList is like stringList for values that may contain commas, such
+// as git settings. Entries are separated by newlines instead.
+type settingList []string
+
+func (s *settingList) String() string {
+	return strings.Join(*s, "\n")
+}
+
+func (s *settingList) Set(value string) error {
+	for _, v := range strings.Split(value, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			*s = append(*s, v)
+		}
 	}
+	return nil
+}
 
-	defaultSSHKeyPath := filepath.Join(homeDir, ".ssh", "id_ed25519")
+func (s *settingList) reset() {
+	*s = nil
+}
+
+func init() {
+	defaultSSHKeyPath = pullio.DefaultSSHKeyPath()
+	
+	// Without a cache directory nothing is recorded unless a path is given.
+	defaultStatePath, _ := state.DefaultPath()
 	
-	flag.StringVar(&sshKeyFlag, "key", defaultSSHKeyPath, "Path to the SSH private key")
+	flag.Var(&keyFlag, "key", fmt.Sprintf("Path to an SSH private key, can be repeated (default %s)", defaultSSHKeyPath))
 	flag.StringVar(&branchesFlag, "branches", "main,master", "Comma-separated list of default branch names to try")
-	flag.IntVar(&concurrentFlag, "concurrent", 4, "Number of repositories to process concurrently")
+	flag.StringVar(&branchesRegexFlag, "branches-regex", "", "Use the first local branch matching this regular expression when none of -branches exists")
+	flag.StringVar(&fallbackFlag, "default-branch-fallback", "", "Branch to try as a last resort when no default branch can be detected")
+	flag.IntVar(&concurrentFlag, "concurrent", 0, "Number of repositories to process, and directories to search, concurrently (0 to pick from the number of CPUs and remote hosts)")
+	flag.IntVar(&scanParallelFlag, "scan-concurrent", 0, "Number of directories to search concurrently, overriding -concurrent")
+	flag.IntVar(&pullParallelFlag, "pull-concurrent", 0, "Number of repositories to process concurrently, overriding -concurrent")
+	flag.IntVar(&perHostFlag, "concurrent-per-host", 4, "Number of repositories with the same remote host to process concurrently (0 for no limit)")
+	flag.StringVar(&metricsFileFlag, "metrics-file", "", "Write Prometheus metrics about the run to this file, e.g. for the node_exporter textfile collector")
+	flag.DurationVar(&watchFlag, "watch", 0, "Update again every this long, e.g. 15m, until interrupted (0 to update once)")
+	flag.DurationVar(&rescanFlag, "rescan", time.Hour, "With -watch, search for repositories again once this long has passed, reusing the ones found until then (0 to search every time)")
+	flag.DurationVar(&maxRuntimeFlag, "max-runtime", 0, "Stop the whole run after this long, e.g. 10m, cancelling the repositories still in progress and printing what was done (0 for no limit)")
+	flag.BoolVar(&waitFlag, "wait", false, "Wait for another run updating the same -path to finish instead of exiting")
 	flag.BoolVar(&verboseFlag, "verbose", false, "Enable verbose output")
+	flag.BoolVar(&quietFlag, "quiet", false, "Only print warnings, errors and the final summary")
+	flag.StringVar(&logFileFlag, "log-file", "", "Append a timestamped copy of the output to this file")
+	flag.StringVar(&logLevelFlag, "log-level", "", "Minimum level of messages to print: debug, info, warn, error or silent (overrides -verbose and -quiet)")
+	flag.StringVar(&colorFlag, "color", "auto", "When to color the output: auto when printing to a terminal and NO_COLOR is not set, always or never")
+	flag.BoolVar(&dryRunFlag, "dry-run", false, "Show what would be updated without checking out or pulling")
+	flag.BoolVar(&stashFlag, "stash", false, "Stash uncommitted changes before pulling and restore them afterwards")
+	flag.BoolVar(&fetchOnlyFlag, "fetch-only", false, "Only fetch remote refs without checking out or pulling")
 	flag.StringVar(&startPath, "path", ".", "Starting path to search for repositories")
+	flag.StringVar(&reposFromFlag, "repos-from", "", "Update the repositories listed in this file, one path per line, instead of searching -path")
+	flag.Var(&excludeFlag, "exclude", "Glob pattern of directories to skip while searching (can be repeated)")
+	flag.Var(&matchFlag, "match", "Only update repositories whose path matches this glob pattern, ** matches any number of directories (can be repeated)")
+	flag.IntVar(&maxReposFlag, "max-repos", 0, "Only process the first N repositories found (0 for no limit)")
+	flag.IntVar(&maxFoundFlag, "max-discovered", 0, "Stop without updating anything when the search finds more than N repositories (0 for no limit)")
+	flag.IntVar(&maxDepthFlag, "max-depth", -1, "Maximum directory depth to search below the starting path (-1 for unlimited)")
+	flag.Var(&skipDirFlag, "skip-dir", fmt.Sprintf("Name or glob pattern of directories to skip at any depth while searching, in addition to %s (can be repeated)", strings.Join(utils.DefaultSkipDirs, ", ")))
+	flag.BoolVar(&noDefaultSkipFlag, "no-default-skip-dirs", false, "Also search the directories skipped by default")
+	flag.BoolVar(&skipHiddenFlag, "skip-hidden", false, "Skip directories whose name starts with a dot while searching")
+	flag.BoolVar(&followLinksFlag, "follow-symlinks", false, "Descend into symbolic links to directories while searching")
+	flag.BoolVar(&nestedFlag, "nested", false, "Also search inside repositories for repositories cloned within them")
+	flag.BoolVar(&worktreesFlag, "worktrees", false, "Also update the linked worktrees of the repositories found, even outside -path")
+	flag.BoolVar(&submodulesFlag, "submodules", false, "Update submodules recursively after pulling")
+	flag.BoolVar(&ffOnlyFlag, "ff-only", false, "Only pull when the branch can be fast-forwarded, never create merge commits")
+	flag.BoolVar(&resetHardFlag, "reset-hard", false, "DANGEROUS: reset branches that cannot be fast-forwarded to the remote, for read-only mirrors; branches with local commits are left alone")
+	flag.BoolVar(&resetForceFlag, "reset-hard-force", false, "DANGEROUS: like -reset-hard, but also discard local commits")
+	flag.BoolVar(&discardAckFlag, "i-know-this-discards-local-changes", false, "Use -reset-hard or -reset-hard-force without being asked to confirm")
+	flag.BoolVar(&currentBranchFlag, "current-branch", false, "Pull the checked out branch if it has an upstream instead of switching to the default branch")
+	flag.BoolVar(&allBranchesFlag, "all-branches", false, "Also fast-forward every other local branch that tracks a remote branch")
+	flag.BoolVar(&forceBranchFlag, "force-branch", false, "Check out the default branch in repositories with a detached HEAD instead of skipping them")
+	flag.StringVar(&preHookFlag, "pre-hook", "", "Shell command to run in each repository before checking out and pulling")
+	flag.StringVar(&postHookFlag, "post-hook", "", "Shell command to run in each repository after a pull that brought in new commits")
+	flag.BoolVar(&ignoreHookErrFlag, "ignore-hook-errors", false, "Report failing hooks without failing the repository")
+	flag.BoolVar(&tagsFlag, "tags", false, "Fetch all tags, replacing local tags that were moved on the remote")
+	flag.BoolVar(&noTagsFlag, "no-tags", false, "Fetch no tags, not even the ones pointing at pulled commits")
+	flag.BoolVar(&pruneFlag, "prune", false, "Remove remote-tracking branches that no longer exist on the remote after pulling")
+	flag.BoolVar(&gcFlag, "gc", false, "Run git maintenance after pulling to pack loose objects")
+	flag.StringVar(&gcIntervalFlag, "gc-interval", "7d", "With -gc, skip repositories that had maintenance run in this long, e.g. 7d or 12h")
+	flag.BoolVar(&confirmFlag, "confirm", false, "Ask before updating the repositories that were found")
+	flag.IntVar(&confirmAboveFlag, "confirm-above", 100, "Ask before updating when more than this many repositories were found (0 to never ask)")
+	flag.BoolVar(&yesFlag, "yes", false, "Proceed without asking for confirmation")
+	flag.BoolVar(&statsFlag, "stats", false, "Count the objects and bytes received from remotes and show the total in the summary")
+	flag.BoolVar(&remoteNameFlag, "name-from-remote", false, "Show repositories as owner/repo from their remote URL instead of their path")
+	flag.StringVar(&verifyCleanFlag, "verify-clean", "", "Check that pulling left the working tree clean: warn or fail")
+	flag.BoolVar(&conflictsFlag, "check-conflicts", false, "Fail repositories whose tracked files contain merge conflict markers after pulling")
+	flag.BoolVar(&unshallowFlag, "unshallow", false, "Fetch the full history of shallow clones before updating them")
+	flag.BoolVar(&strictFlag, "strict", false, "Exit with a failure status when any repository was skipped")
+	flag.BoolVar(&failFastFlag, "fail-fast", false, "Cancel the remaining repositories as soon as one fails")
+	flag.Var(&gitConfigFlag, "git-config", "Git setting in key=value form applied to every git command, e.g. http.proxy=... (can be repeated)")
+	flag.BoolVar(&useKeychainFlag, "use-keychain", false, "Take SSH key passphrases from the macOS keychain or the Secret Service keyring (secret-tool)")
+	flag.StringVar(&credHelperFlag, "credential-helper", "", "Git credential helper to use for HTTPS remotes")
+	flag.StringVar(&backendFlag, "backend", "git", "How git operations are performed: git runs the git binary, go-git uses a built-in implementation that needs no git installed")
+	flag.BoolVar(&listFlag, "list", false, "Only list the repositories that would be updated, with their remote URL and branch, and exit")
+	flag.StringVar(&reportFlag, "report", "", "Report instead of updating: ahead lists the repositories with commits or changes that are not pushed, without fetching, and fsck the ones git fsck finds corrupt")
+	flag.DurationVar(&timeoutFlag, "timeout", 0, "Abort a git command that runs longer than this, e.g. 30s (0 for no limit)")
+	flag.StringVar(&sinceFlag, "since", "", "Skip repositories without commits or checkouts in this long, e.g. 7d, 2w or 12h")
+	flag.StringVar(&remoteFilterFlag, "remote-filter", "", "Only update repositories whose remote URL matches this regular expression, e.g. github.com/my-org")
+	flag.StringVar(&remoteFlag, "remote", gitmanager.DefaultRemote, "Name of the remote to update from, e.g. upstream")
+	flag.StringVar(&stateFileFlag, "state-file", defaultStatePath, "File the results of each run are recorded in for -only-failed (empty to disable)")
+	flag.BoolVar(&onlyFailedFlag, "only-failed", false, "Only retry the repositories that failed in the previous run")
+	flag.BoolVar(&openRequestsFlag, "open-requests", false, "Show the number of open pull or merge requests of repositories on GitHub or GitLab (needs GITHUB_TOKEN or GITLAB_TOKEN)")
+	flag.BoolVar(&notifyFlag, "notify", false, "Show a desktop notification when the run is finished")
+	flag.StringVar(&outputFlag, "output", "text", "Summary format: text, table, json or csv")
+	flag.StringVar(&templateFlag, "template", "", "Go text/template executed for each repository's result to print the summary, e.g. '{{.Path}} {{.Branch}}'")
+	flag.StringVar(&eventsFlag, "events", "", "Stream progress as JSON lines to this file, unix:SOCKET, or - for stdout")
 	
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
@@ -44,78 +257,786 @@ func init() {
 	}
 }
 
-func main() {
-	flag.Parse()
+// envName returns the environment variable that can set the given flag,
+// e.g. PULLIO_DRY_RUN for -dry-run.
+func envName(flagName string) string {
+	return "PULLIO_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applySettings sets every flag that was not given on the command line from
+// its PULLIO_* environment variable or, failing that, from cfg.
+func applySettings(cfg *config.Config) error {
+	values := cfg.FlagValues()
+	
+	var applyErr error
+	flag.VisitAll(func(f *flag.Flag) {
+		if applyErr != nil || explicitFlags[f.Name] {
+			return
+		}
+		
+		// Lists are replaced rather than appended to when set from outside
+		// the command line.
+		if list, ok := f.Value.(interface{ reset() }); ok {
+			if _, isSet := os.LookupEnv(envName(f.Name)); isSet || values[f.Name] != "" {
+				list.reset()
+			}
+		}
+		
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				applyErr = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err)
+			}
+			return
+		}
+		
+		if value, ok := values[f.Name]; ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				applyErr = fmt.Errorf("invalid value %q for %s in config: %w", value, f.Name, err)
+			}
+		}
+	})
+	
+	return applyErr
+}
+
+// loadConfig layers the per-user config file and the .pullio.yaml in the
+// start path, then applies the result to the flags.
+func loadConfig() (*config.Config, error) {
+	cfg := &config.Config{}
+	
+	userConfigPath, err := config.UserConfigPath()
+	if err == nil {
+		userConfig, err := config.Load(userConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Merge(userConfig)
+	}
+	
+	// The start path itself may come from the user config or environment.
+	if err := applySettings(cfg); err != nil {
+		return nil, err
+	}
+	
+	// A start path that is not a directory is reported once the search
+	// starts.
+	root, err := utils.ExpandPath(startPath)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err == nil && info.IsDir() {
+		treeConfig, err := config.Load(filepath.Join(root, config.FileName))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Merge(treeConfig)
+	}
+	
+	if err := applySettings(cfg); err != nil {
+		return nil, err
+	}
+	
+	// Paths from config files are not expanded by a shell.
+	if startPath, err = utils.ExpandPath(startPath); err != nil {
+		return nil, err
+	}
+	if logFileFlag, err = utils.ExpandPath(logFileFlag); err != nil {
+		return nil, err
+	}
+	for i, o := range cfg.Overrides {
+		if cfg.Overrides[i].Key, err = utils.ExpandPath(o.Key); err != nil {
+			return nil, err
+		}
+	}
+	
+	return cfg, nil
+}
+
+// repoOptions applies the config overrides matching repoPath on top of opts.
+// Settings given explicitly on the command line still win.
+func repoOptions(cfg *config.Config, repoPath string, opts gitmanager.Options) gitmanager.Options {
+	for _, o := range cfg.OverridesFor(repoPath) {
+		if o.Branches != nil && !explicitFlags["branches"] {
+			opts.DefaultBranches = o.Branches
+		}
+		if o.Stash != nil && !explicitFlags["stash"] {
+			opts.Stash = *o.Stash
+		}
+		if o.FetchOnly != nil && !explicitFlags["fetch-only"] {
+			opts.FetchOnly = *o.FetchOnly
+		}
+		if o.Key != "" {
+			opts.SSHKey = o.Key
+		}
+	}
+	
+	return opts
+}
+
+// gcDue reports whether maintenance should run in repoPath, given when it
+// last ran in each repository.
+func gcDue(lastGC map[string]time.Time, repoPath string, interval time.Duration) bool {
+	last, ok := lastGC[repoPath]
+	if ok && time.Since(last) < interval {
+		logger.Debug("Maintenance ran in %s %v ago, not running it again", repoPath, time.Since(last).Round(time.Second))
+		return false
+	}
+	return true
+}
+
+// recordGC adds the repositories maintenance ran in to lastGC and reports
+// whether there were any.
+func recordGC(lastGC map[string]time.Time, results []gitmanager.RepoResult) bool {
+	recorded := false
+	for _, r := range results {
+		if r.GC {
+			lastGC[r.Path] = time.Now()
+			recorded = true
+		}
+	}
+	return recorded
+}
+
+// Exit codes. Setup failures such as a bad configuration, the SSH agent or
+// repository discovery exit with logger.FatalExitCode.
+const (
+	exitOK          = 0
+	exitReposFailed = 1
+)
+
+// exitCode returns the status for a finished run.
+func exitCode(summary report.Summary) int {
+	g := summary.Group()
+	if len(g.Failed) > 0 || len(g.Cancelled) > 0 {
+		return exitReposFailed
+	}
+	for _, r := range g.Succeeded {
+		if r.Corrupt {
+			return exitReposFailed
+		}
+	}
 	
-	logger.SetVerbose(verboseFlag)
-	defaultBranches := strings.Split(branchesFlag, ",")
+	// Stale repositories were asked to be skipped and do not count.
+	if strictFlag {
+		for _, r := range g.Skipped {
+			if !r.Stale {
+				return exitReposFailed
+			}
+		}
+	}
 	
-	logger.Info("Initializing SSH agent...")
-	if err := sshagent.EnsureAgentAndKey(sshKeyFlag); err != nil {
-		logger.Fatal("SSH Agent setup failed: %v", err)
+	return exitOK
+}
+
+// parseAge parses a duration that may also be given in days or weeks, such
+// as 7d or 2w.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			count, err := strconv.Atoi(n)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid age %q", s)
+			}
+			return time.Duration(count) * unit, nil
+		}
 	}
 	
-	logger.Info("Finding Git repositories from %s...", startPath)
-	startTime := time.Now()
-	gitDirs, err := utils.FindGitDirs(startPath)
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q, expected e.g. 7d, 2w or 12h", s)
+	}
+	return d, nil
+}
+
+// previouslyFailed returns the repositories that failed in the run recorded
+// in the state file and still exist as repositories.
+func previouslyFailed(ctx context.Context, backend gitmanager.Git) []string {
+	failed, err := state.FailedPaths(stateFileFlag)
 	if err != nil {
-		logger.Fatal("Failed to find Git directories: %v", err)
+		logger.Fatal("Failed to read previous results: %v", err)
+	}
+	
+	var repoPaths []string
+	for _, repoPath := range failed {
+		if _, err := os.Stat(repoPath); err != nil {
+			logger.Warning("%s no longer exists, not retrying it", repoPath)
+			continue
+		}
+		if !backend.IsGitRepo(ctx, repoPath) {
+			logger.Warning("%s is no longer a Git repository, not retrying it", repoPath)
+			continue
+		}
+		repoPaths = append(repoPaths, repoPath)
+	}
+	
+	logger.Info("Retrying %d repositories that failed in the previous run", len(repoPaths))
+	return repoPaths
+}
+
+// confirmRun asks on the terminal whether to go ahead with updating count
+// repositories and reports whether the answer was yes.
+func confirmRun(count int) bool {
+	fmt.Fprintf(os.Stderr, "About to update %d repositories. Proceed? [y/N] ", count)
+	return readYes()
+}
+
+// confirmReset asks on the terminal whether to go ahead with updating count
+// repositories with -reset-hard and reports whether the answer was yes.
+func confirmReset(count int) bool {
+	discarded := "commits that are no longer on the remote"
+	if resetForceFlag {
+		discarded = "local commits too"
+	}
+	fmt.Fprintf(os.Stderr, "About to update %d repositories and reset the ones that cannot be fast-forwarded, discarding %s. Proceed? [y/N] ", count, discarded)
+	return readYes()
+}
+
+// readYes reads an answer from the terminal and reports whether it was yes.
+func readYes() bool {
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirm decides whether to go ahead with updating count repositories.
+// It gives a chance to back out before touching many repositories, e.g. when
+// pointed at the wrong directory. Without a terminal only an explicit
+// -confirm stops the run.
+func confirm(count int) bool {
+	// Resetting can throw work away, so -yes does not skip asking.
+	if (resetHardFlag || resetForceFlag) && !discardAckFlag && !dryRunFlag && reportFlag == "" {
+		return confirmReset(count)
+	}
+	
+	askFirst := confirmFlag || (confirmAboveFlag > 0 && count > confirmAboveFlag)
+	if !askFirst || dryRunFlag || reportFlag != "" || yesFlag {
+		return true
+	}
+	
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		return confirmRun(count)
+	}
+	if confirmFlag {
+		logger.Fatal("-confirm needs a terminal to ask on, pass -yes to proceed without asking")
+	}
+	return true
+}
+
+// printSummary writes the final summary in the selected output format.
+func printSummary(summary report.Summary) {
+	// Events streamed to stdout leave it to them alone.
+	var out io.Writer = os.Stdout
+	if eventsFlag == "-" {
+		out = os.Stderr
+	}
+	
+	w := out
+	if logFile := logger.LogFile(); logFile != nil {
+		w = io.MultiWriter(out, logFile)
+	}
+	
+	if summaryTemplate != nil {
+		if err := report.WriteTemplate(w, summary, summaryTemplate); err != nil {
+			logger.Fatal("Failed to write summary: %v", err)
+		}
+		return
+	}
+	
+	switch outputFlag {
+	case "json":
+		if err := report.WriteJSON(w, summary); err != nil {
+			logger.Fatal("Failed to write summary: %v", err)
+		}
+	case "csv":
+		if err := report.WriteCSV(w, summary); err != nil {
+			logger.Fatal("Failed to write summary: %v", err)
+		}
+	case "table":
+		report.WriteTable(w, summary)
+	default:
+		report.WriteText(w, summary)
+	}
+}
+
+// printList writes the repositories found by -list in the selected output
+// format.
+func printList(repos []pullio.Repository) {
+	if outputFlag == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(repos); err != nil {
+			logger.Fatal("Failed to write repositories: %v", err)
+		}
+		return
+	}
+	if outputFlag == "csv" {
+		out := csv.NewWriter(os.Stdout)
+		out.Write([]string{"path", "url", "branch"})
+		for _, repo := range repos {
+			out.Write([]string{repo.Path, repo.URL, repo.Branch})
+		}
+		if out.Flush(); out.Error() != nil {
+			logger.Fatal("Failed to write repositories: %v", out.Error())
+		}
+		return
 	}
-	logger.Success("Found %d Git repositories in %v", len(gitDirs), time.Since(startTime))
 	
-	if len(gitDirs) == 0 {
-		logger.Info("No Git repositories found. Exiting.")
+	for _, repo := range repos {
+		var details []string
+		if repo.Branch != "" {
+			details = append(details, "branch: "+repo.Branch)
+		}
+		if repo.URL != "" {
+			details = append(details, remoteFlag+": "+repo.URL)
+		} else {
+			details = append(details, "no "+remoteFlag+" remote")
+		}
+		fmt.Printf("%s (%s)\n", repo.Path, strings.Join(details, ", "))
+	}
+}
+
+func main() {
+	if sshagent.HandleAskpass() {
 		return
 	}
+	runStart := time.Now()
+	
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
 	
-	// Process repositories concurrently
-	resultChan := make(chan gitmanager.RepoResult, len(gitDirs))
-	sem := make(chan struct{}, concurrentFlag)
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Fatal("Failed to load configuration: %v", err)
+	}
 	
-	var wg sync.WaitGroup
-	for _, gitDir := range gitDirs {
-		wg.Add(1)
-		sem <- struct{}{}
+	level := logger.LevelInfo
+	switch {
+	case quietFlag && verboseFlag:
+		logger.Fatal("-quiet and -verbose cannot be combined")
+	case quietFlag:
+		level = logger.LevelWarn
+	case verboseFlag:
+		level = logger.LevelDebug
+	}
+	if logLevelFlag != "" {
+		if level, err = logger.ParseLevel(logLevelFlag); err != nil {
+			logger.Fatal("%v", err)
+		}
+	}
+	logger.SetLevel(level)
+	
+	if logFileFlag != "" {
+		logFile, err := os.OpenFile(logFileFlag, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			logger.Fatal("Failed to open log file: %v", err)
+		}
+		logger.SetLogFile(logFile)
+	}
+	
+	switch outputFlag {
+	case "text", "table":
+	case "json", "csv":
+		// Keep stdout for the machine-readable summary only.
+		logger.SetOutput(os.Stderr)
+	default:
+		logger.Fatal("Unknown output format %q, expected text, table, json or csv", outputFlag)
+	}
+	if templateFlag != "" {
+		if outputFlag != "text" {
+			logger.Fatal("-template and -output cannot be used together")
+		}
+		summaryTemplate, err = report.ParseTemplate(templateFlag)
+		if err != nil {
+			logger.Fatal("Invalid -template: %v", err)
+		}
+	}
+	
+	var emitter *events.Emitter
+	if eventsFlag != "" {
+		if eventsFlag == "-" && (outputFlag == "json" || outputFlag == "csv") {
+			logger.Fatal("-events - and -output %s cannot both write to stdout", outputFlag)
+		}
+		if eventsFlag == "-" {
+			logger.SetOutput(os.Stderr)
+		}
 		
-		go func(dir string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-			
-			repoPath := filepath.Dir(dir)
-			result := gitmanager.ProcessRepository(repoPath, defaultBranches)
-			resultChan <- result
-		}(gitDir)
+		emitter, err = events.Open(eventsFlag)
+		if err != nil {
+			logger.Fatal("Failed to open events stream: %v", err)
+		}
+	}
+	
+	switch colorFlag {
+	case "auto":
+		// Output piped to another program or redirected to a file is left
+		// plain.
+		if !logger.ConsoleIsTerminal() {
+			logger.SetColors(false)
+		}
+	case "always":
+		logger.SetColors(true)
+	case "never":
+		logger.SetColors(false)
+	default:
+		logger.Fatal("Unknown -color mode %q, expected auto, always or never", colorFlag)
+	}
+	
+	switch verifyCleanFlag {
+	case "", "warn", "fail":
+	default:
+		logger.Fatal("Unknown -verify-clean mode %q, expected warn or fail", verifyCleanFlag)
+	}
+	
+	// Without a terminal to confirm on, resetting must be acknowledged up
+	// front rather than after the search. Dry runs and reports reset
+	// nothing, so they are not asked either way.
+	if (resetHardFlag || resetForceFlag) && !discardAckFlag && !dryRunFlag && reportFlag == "" && !term.IsTerminal(int(os.Stdin.Fd())) {
+		logger.Fatal("-reset-hard needs a terminal to confirm on, pass -i-know-this-discards-local-changes to proceed without asking")
+	}
+	
+	switch reportFlag {
+	case "", "ahead", "fsck":
+	default:
+		logger.Fatal("Unknown -report mode %q, expected ahead or fsck", reportFlag)
+	}
+	
+	backend, err := gitmanager.NewBackend(backendFlag)
+	if err != nil {
+		logger.Fatal("Invalid -backend: %v", err)
+	}
+	
+	for _, entry := range gitConfigFlag {
+		if err := gitmanager.ValidateGitConfig(entry); err != nil {
+			logger.Fatal("Invalid -git-config: %v", err)
+		}
+	}
+	
+	if tagsFlag && noTagsFlag {
+		logger.Fatal("-tags and -no-tags cannot be used together")
+	}
+	
+	if _, ok := backend.(gitmanager.GoGit); ok && (len(gitConfigFlag) > 0 || credHelperFlag != "") {
+		logger.Fatal("-git-config and -credential-helper cannot be used with -backend go-git")
+	}
+	
+	if onlyFailedFlag && reposFromFlag != "" {
+		logger.Fatal("-only-failed and -repos-from cannot be used together")
+	}
+	if onlyFailedFlag && stateFileFlag == "" {
+		logger.Fatal("-only-failed needs a -state-file")
+	}
+	
+	var since time.Duration
+	if sinceFlag != "" {
+		since, err = parseAge(sinceFlag)
+		if err != nil {
+			logger.Fatal("Invalid -since: %v", err)
+		}
+	}
+	
+	var gcInterval time.Duration
+	if gcFlag {
+		gcInterval, err = parseAge(gcIntervalFlag)
+		if err != nil {
+			logger.Fatal("Invalid -gc-interval: %v", err)
+		}
+	}
+	
+	for _, pattern := range matchFlag {
+		if err := utils.ValidateMatchPattern(pattern); err != nil {
+			logger.Fatal("Invalid -match: %v", err)
+		}
+	}
+	
+	var remoteFilter *regexp.Regexp
+	if remoteFilterFlag != "" {
+		remoteFilter, err = regexp.Compile(remoteFilterFlag)
+		if err != nil {
+			logger.Fatal("Invalid -remote-filter: %v", err)
+		}
+	}
+	
+	var branchPattern *regexp.Regexp
+	if branchesRegexFlag != "" {
+		branchPattern, err = regexp.Compile(branchesRegexFlag)
+		if err != nil {
+			logger.Fatal("Invalid -branches-regex: %v", err)
+		}
+	}
+	// Maintenance is expensive, so it only runs again in a repository once
+	// -gc-interval has passed.
+	lastGC := map[string]time.Time{}
+	gcStatePath, err := state.DefaultGCPath()
+	if gcFlag && err == nil {
+		if lastGC, err = state.LastGC(gcStatePath); err != nil {
+			logger.Warning("Failed to read when maintenance last ran: %v", err)
+			lastGC = map[string]time.Time{}
+		}
+	}
+	
+	opts := pullio.Options{
+		Path:            startPath,
+		Exclude:         excludeFlag,
+		Match:           matchFlag,
+		SkipDirs:        append([]string(nil), skipDirFlag...),
+		SkipHidden:      skipHiddenFlag,
+		MaxDepth:        maxDepthFlag,
+		FollowSymlinks:  followLinksFlag,
+		Nested:          nestedFlag,
+		Worktrees:       worktreesFlag,
+		MaxRepos:        maxReposFlag,
+		MaxDiscovered:   maxFoundFlag,
+		RemoteFilter:    remoteFilter,
+		Since:           since,
+		Concurrency:     cmp.Or(pullParallelFlag, concurrentFlag),
+		ScanConcurrency: cmp.Or(scanParallelFlag, concurrentFlag),
+		PerHost:         perHostFlag,
+		FailFast:        failFastFlag,
+		SSHKeys:         keyFlag,
+		UseKeychain:     useKeychainFlag,
+		GitConfig:       gitConfigFlag,
+		Remote:          remoteFlag,
+		OpenRequests:    openRequestsFlag,
+		Git:             backend,
+		Repo: pullio.RepoOptions{
+			DefaultBranches:      strings.Split(branchesFlag, ","),
+			DefaultBranchPattern: branchPattern,
+			FallbackBranch:       fallbackFlag,
+			DryRun:               dryRunFlag,
+			Stash:                stashFlag,
+			FetchOnly:            fetchOnlyFlag,
+			Submodules:           submodulesFlag,
+			FFOnly:               ffOnlyFlag,
+			ResetHard:            resetHardFlag || resetForceFlag,
+			ResetForce:           resetForceFlag,
+			CredentialHelper:     credHelperFlag,
+			ForceBranch:          forceBranchFlag,
+			CurrentBranch:        currentBranchFlag,
+			AllBranches:          allBranchesFlag,
+			Tags:                 tagsFlag,
+			NoTags:               noTagsFlag,
+			PreHook:              preHookFlag,
+			PostHook:             postHookFlag,
+			IgnoreHookErrors:     ignoreHookErrFlag,
+			Prune:                pruneFlag,
+			GC:                   gcFlag,
+			Unshallow:            unshallowFlag,
+			VerifyClean:          verifyCleanFlag,
+			CheckConflicts:       conflictsFlag,
+			Stats:                statsFlag,
+			NameFromRemote:       remoteNameFlag,
+			Timeout:              timeoutFlag,
+			ReportAhead:          reportFlag == "ahead",
+			ReportFsck:           reportFlag == "fsck",
+		},
+		Override: func(repoPath string, opts pullio.RepoOptions) pullio.RepoOptions {
+			opts = repoOptions(cfg, repoPath, opts)
+			if opts.GC && !gcDue(lastGC, repoPath, gcInterval) {
+				opts.GC = false
+			}
+			return opts
+		},
+		Confirm:  confirm,
+		Started:  status.started,
+		Finished: status.finished,
+	}
+	if !noDefaultSkipFlag {
+		opts.SkipDirs = append(opts.SkipDirs, utils.DefaultSkipDirs...)
 	}
 	
+	// The first interrupt stops new work and aborts running git commands so
+	// a summary can still be printed; a second one exits right away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx = events.WithEmitter(ctx, emitter)
 	go func() {
-		wg.Wait()
-		close(resultChan)
+		<-ctx.Done()
+		stop()
+		logger.Warning("Interrupted, cancelling remaining repositories (press Ctrl-C again to exit immediately)")
 	}()
+	printStatusOnSignal()
 	
-	// Collect results
-	var succeeded, failed []gitmanager.RepoResult
-	for result := range resultChan {
-		if result.Success {
-			succeeded = append(succeeded, result)
-		} else {
-			failed = append(failed, result)
+	// Reaching the deadline cancels the run just like an interrupt, so runs
+	// from cron cannot go on forever.
+	if maxRuntimeFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, maxRuntimeFlag)
+		defer cancel()
+		context.AfterFunc(ctx, func() {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				logger.Warning("Reached the -max-runtime of %v, cancelling remaining repositories", maxRuntimeFlag)
+			}
+		})
+	}
+	
+	if onlyFailedFlag {
+		opts.Repos = previouslyFailed(ctx, opts.Git)
+	} else if reposFromFlag != "" {
+		// An explicit list replaces the search. Paths that do not exist or
+		// are not repositories are reported as failed like any other.
+		opts.Repos, err = utils.ReadRepoList(reposFromFlag)
+		if err != nil {
+			logger.Fatal("Failed to read repositories: %v", err)
 		}
+		logger.Success("Read %d repositories from %s", len(opts.Repos), reposFromFlag)
 	}
 	
-	// Print summary
-	fmt.Printf("\n📦 Done. %d updated, %d failed.\n", len(succeeded), len(failed))
+	if listFlag {
+		repos, err := pullio.List(ctx, opts)
+		switch {
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			logger.Warning("Cancelled while searching for repositories")
+			os.Exit(exitReposFailed)
+		case errors.Is(err, utils.ErrStartPathNotFound) || errors.Is(err, utils.ErrStartPathNotDir):
+			logger.Fatal("Invalid -path: %v", errors.Unwrap(err))
+		case err != nil:
+			logger.Fatal("Failed to list repositories: %v", err)
+		}
+		
+		printList(repos)
+		return
+	}
 	
-	if len(succeeded) > 0 {
-		fmt.Println("\nSuccessfully updated repositories:")
-		for _, r := range succeeded {
-			fmt.Printf("✅ %s (branch: %s)\n", r.Path, r.Branch)
+	// Runs that change nothing can overlap with others.
+	var lock *state.Lock
+	if !dryRunFlag && reportFlag == "" {
+		lock = lockTree(ctx)
+	}
+	
+	code := exitOK
+	if watchFlag > 0 {
+		code = watch(ctx, opts, lastGC, gcStatePath)
+	} else {
+		code = update(ctx, opts, runStart, lastGC, gcStatePath)
+	}
+	lock.Release()
+	os.Exit(code)
+}
+
+// lockTree takes the lock for the tree at -path, so two runs, such as one
+// from cron and one started by hand, do not update the same repositories at
+// once. It exits when another run holds the lock, unless -wait is set.
+func lockTree(ctx context.Context) *state.Lock {
+	path, err := state.LockPath(startPath)
+	if err != nil {
+		logger.Fatal("Failed to lock %s: %v", startPath, err)
+	}
+	
+	lock, err := state.AcquireLock(ctx, path, false)
+	if errors.Is(err, state.ErrLocked) && waitFlag {
+		logger.Info("Waiting for another pullio run on %s to finish...", startPath)
+		lock, err = state.AcquireLock(ctx, path, true)
+	}
+	switch {
+	case errors.Is(err, state.ErrLocked):
+		logger.Fatal("Cannot update %s: %v, pass -wait to wait for it to finish", startPath, err)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		logger.Warning("Cancelled while waiting for another run to finish")
+		os.Exit(exitReposFailed)
+	case err != nil:
+		logger.Fatal("%v", err)
+	}
+	
+	return lock
+}
+
+// update runs one update of the repositories selected by opts, started at
+// start, prints its summary and records its results. It returns the exit
+// code for the run.
+func update(ctx context.Context, opts pullio.Options, start time.Time, lastGC map[string]time.Time, gcStatePath string) int {
+	status.reset(start)
+	summary, err := pullio.Update(ctx, opts)
+	switch {
+	case errors.Is(err, pullio.ErrAborted):
+		logger.Info("Aborted, no repositories were updated")
+		os.Exit(exitOK)
+	case errors.Is(err, pullio.ErrTooManyRepos):
+		logger.Fatal("Stopping before updating anything, %v; check -path or raise -max-discovered", err)
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		logger.Warning("Cancelled while searching for repositories")
+		os.Exit(exitReposFailed)
+	case errors.Is(err, utils.ErrStartPathNotFound) || errors.Is(err, utils.ErrStartPathNotDir):
+		logger.Fatal("Invalid -path: %v", errors.Unwrap(err))
+	case err != nil:
+		logger.Fatal("Failed to update repositories: %v", err)
+	}
+	
+	// Runs that found nothing are recorded too, so monitoring can tell them
+	// apart from runs that did not happen.
+	if metricsFileFlag != "" && !dryRunFlag && reportFlag == "" {
+		if err := metrics.WriteFile(metricsFileFlag, summary, time.Since(start), time.Now()); err != nil {
+			logger.Warning("Failed to write metrics: %v", err)
 		}
 	}
 	
-	if len(failed) > 0 {
-		fmt.Println("\nFailed repositories:")
-		for _, r := range failed {
-			fmt.Printf("❌ %s (reason: %s)\n", r.Path, r.ErrorMessage)
+	if len(summary.Results) == 0 {
+		if outputFlag == "json" || outputFlag == "csv" {
+			printSummary(summary)
+		}
+		return exitOK
+	}
+	
+	printSummary(summary)
+	
+	// A dry run changes nothing, so the previous results stay relevant.
+	if stateFileFlag != "" && !dryRunFlag && reportFlag == "" {
+		if err := state.Save(stateFileFlag, summary.Results); err != nil {
+			logger.Warning("Failed to record results: %v", err)
+		}
+	}
+	
+	if gcFlag && gcStatePath != "" && recordGC(lastGC, summary.Results) {
+		if err := state.SaveGC(gcStatePath, lastGC); err != nil {
+			logger.Warning("Failed to record when maintenance ran: %v", err)
+		}
+	}
+	
+	if notifyFlag {
+		if err := notify.Send("pullio", report.Headline(summary)); err != nil {
+			logger.Warning("Failed to send notification: %v", err)
+		}
+	}
+	
+	return exitCode(summary)
+}
+
+// watch updates the repositories selected by opts every -watch until ctx is
+// cancelled and returns the exit code of the last update. The search is
+// only repeated once -rescan has passed; until then the repositories it
+// found are updated again.
+func watch(ctx context.Context, opts pullio.Options, lastGC map[string]time.Time, gcStatePath string) int {
+	search := opts.Repos == nil
+	var lastSearch time.Time
+	code := exitOK
+	for {
+		if search && time.Since(lastSearch) >= rescanFlag {
+			found, err := pullio.Find(ctx, opts)
+			switch {
+			case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+				return code
+			case errors.Is(err, utils.ErrStartPathNotFound) || errors.Is(err, utils.ErrStartPathNotDir):
+				logger.Fatal("Invalid -path: %v", errors.Unwrap(err))
+			case err != nil:
+				logger.Fatal("Failed to find repositories: %v", err)
+			}
+			opts.Repos = found
+			lastSearch = time.Now()
+		}
+		
+		start := time.Now()
+		logger.Info("Updating at %s", start.Format(time.DateTime))
+		code = update(ctx, opts, start, lastGC, gcStatePath)
+		// Once is enough to confirm, later updates go ahead unattended.
+		opts.Confirm = nil
+		
+		next := start.Add(watchFlag)
+		logger.Info("Next update at %s, press Ctrl-C to stop", next.Format(time.TimeOnly))
+		select {
+		case <-ctx.Done():
+			return code
+		case <-time.After(time.Until(next)):
 		}
 	}
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lyubomir-bozhinov/pullio/pkg/pullio"
+)
+
+// status tracks the repositories of the current run, for printing on
+// request while it goes on.
+var status runStatus
+
+// runStatus counts the repositories that are done and holds the ones in
+// progress with the time they started.
+type runStatus struct {
+	mu      sync.Mutex
+	start   time.Time
+	done    int
+	running map[string]time.Time
+}
+
+// reset starts tracking a new run that started at start.
+func (s *runStatus) reset(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	s.start = start
+	s.done = 0
+	s.running = make(map[string]time.Time)
+}
+
+func (s *runStatus) started(repoPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	if s.running == nil {
+		s.running = make(map[string]time.Time)
+	}
+	s.running[repoPath] = time.Now()
+}
+
+func (s *runStatus) finished(result pullio.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	delete(s.running, result.Path)
+	s.done++
+}
+
+// write prints how long the run has taken, how many repositories are done
+// and the ones in progress, longest running first, since those are the
+// ones that may be stuck.
+func (s *runStatus) write(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	now := time.Now()
+	fmt.Fprintf(w, "\n📊 %v elapsed, %d done, %d in progress\n", now.Sub(s.start).Round(time.Second), s.done, len(s.running))
+	
+	paths := make([]string, 0, len(s.running))
+	for path := range s.running {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return s.running[paths[i]].Before(s.running[paths[j]])
+	})
+	for _, path := range paths {
+		fmt.Fprintf(w, "⏳ %s (%v)\n", path, now.Sub(s.running[path]).Round(time.Second))
+	}
+}
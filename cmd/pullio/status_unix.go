@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// printStatusOnSignal prints the status of the run to stderr whenever the
+// process receives SIGUSR1.
+func printStatusOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			status.write(os.Stderr)
+		}
+	}()
+}
@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// printStatusOnSignal does nothing, since Windows has no SIGUSR1.
+func printStatusOnSignal() {}
@@ -0,0 +1,652 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the per-tree config file looked up in the start path.
+const FileName = ".pullio.yaml"
+
+// Config mirrors the command-line flags. Keys use the same names as the
+// flags and unset values are nil so files can be layered on top of each other.
+type Config struct {
+	Key              Strings        `yaml:"key"`
+	Branches         []string       `yaml:"branches"`
+	BranchesRegex    *string        `yaml:"branches-regex"`
+	FallbackBranch   *string        `yaml:"default-branch-fallback"`
+	Concurrent       *int           `yaml:"concurrent"`
+	ScanConcurrent   *int           `yaml:"scan-concurrent"`
+	PullConcurrent   *int           `yaml:"pull-concurrent"`
+	PerHost          *int           `yaml:"concurrent-per-host"`
+	Verbose          *bool          `yaml:"verbose"`
+	Quiet            *bool          `yaml:"quiet"`
+	LogLevel         *string        `yaml:"log-level"`
+	Color            *string        `yaml:"color"`
+	LogFile          *string        `yaml:"log-file"`
+	Path             *string        `yaml:"path"`
+	ReposFrom        *string        `yaml:"repos-from"`
+	DryRun           *bool          `yaml:"dry-run"`
+	Stash            *bool          `yaml:"stash"`
+	FetchOnly        *bool          `yaml:"fetch-only"`
+	Submodules       *bool          `yaml:"submodules"`
+	FFOnly           *bool          `yaml:"ff-only"`
+	ForceBranch      *bool          `yaml:"force-branch"`
+	CurrentBranch    *bool          `yaml:"current-branch"`
+	AllBranches      *bool          `yaml:"all-branches"`
+	Tags             *bool          `yaml:"tags"`
+	NoTags           *bool          `yaml:"no-tags"`
+	PreHook          *string        `yaml:"pre-hook"`
+	PostHook         *string        `yaml:"post-hook"`
+	IgnoreHookErrors *bool          `yaml:"ignore-hook-errors"`
+	Prune            *bool          `yaml:"prune"`
+	GC               *bool          `yaml:"gc"`
+	GCInterval       *string        `yaml:"gc-interval"`
+	Unshallow        *bool          `yaml:"unshallow"`
+	VerifyClean      *string        `yaml:"verify-clean"`
+	CheckConflicts   *bool          `yaml:"check-conflicts"`
+	Stats            *bool          `yaml:"stats"`
+	NameFromRemote   *bool          `yaml:"name-from-remote"`
+	Strict           *bool          `yaml:"strict"`
+	FailFast         *bool          `yaml:"fail-fast"`
+	Confirm          *bool          `yaml:"confirm"`
+	ConfirmAbove     *int           `yaml:"confirm-above"`
+	Yes              *bool          `yaml:"yes"`
+	CredentialHelper *string        `yaml:"credential-helper"`
+	Backend          *string        `yaml:"backend"`
+	Report           *string        `yaml:"report"`
+	UseKeychain      *bool          `yaml:"use-keychain"`
+	GitConfig        []string       `yaml:"git-config"`
+	Timeout          *time.Duration `yaml:"timeout"`
+	MaxRuntime       *time.Duration `yaml:"max-runtime"`
+	Wait             *bool          `yaml:"wait"`
+	Watch            *time.Duration `yaml:"watch"`
+	Rescan           *time.Duration `yaml:"rescan"`
+	MetricsFile      *string        `yaml:"metrics-file"`
+	Exclude          []string       `yaml:"exclude"`
+	Match            []string       `yaml:"match"`
+	MaxDepth         *int           `yaml:"max-depth"`
+	SkipDir          []string       `yaml:"skip-dir"`
+	NoDefaultSkip    *bool          `yaml:"no-default-skip-dirs"`
+	SkipHidden       *bool          `yaml:"skip-hidden"`
+	FollowSymlinks   *bool          `yaml:"follow-symlinks"`
+	Nested           *bool          `yaml:"nested"`
+	Worktrees        *bool          `yaml:"worktrees"`
+	MaxRepos         *int           `yaml:"max-repos"`
+	MaxDiscovered    *int           `yaml:"max-discovered"`
+	RemoteFilter     *string        `yaml:"remote-filter"`
+	Remote           *string        `yaml:"remote"`
+	Since            *string        `yaml:"since"`
+	OpenRequests     *bool          `yaml:"open-requests"`
+	Notify           *bool          `yaml:"notify"`
+	StateFile        *string        `yaml:"state-file"`
+	Output           *string        `yaml:"output"`
+	Template         *string        `yaml:"template"`
+	Events           *string        `yaml:"events"`
+	Overrides        []Override     `yaml:"overrides"`
+}
+
+// Strings is a list that may also be written as a single scalar value.
+type Strings []string
+
+func (s *Strings) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = Strings{value.Value}
+		return nil
+	}
+	
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*s = list
+	return nil
+}
+
+// Override changes settings for repositories whose path matches Path.
+type Override struct {
+	Path      string   `yaml:"path"`
+	Branches  []string `yaml:"branches"`
+	Stash     *bool    `yaml:"stash"`
+	FetchOnly *bool    `yaml:"fetch-only"`
+	// Key is the only SSH key offered for the matching repositories.
+	Key string `yaml:"key"`
+}
+
+// UserConfigPath returns the location of the per-user config file.
+func UserConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	
+	return filepath.Join(homeDir, ".config", "pullio", "config.yaml"), nil
+}
+
+// Load reads the config file at path. A missing file is not an error and
+// yields an empty Config.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+	
+	cfg := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	
+	baseDir := filepath.Dir(path)
+	for i := range cfg.Overrides {
+		pattern, err := resolvePattern(baseDir, cfg.Overrides[i].Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid override in config %s: %w", path, err)
+		}
+		cfg.Overrides[i].Path = pattern
+	}
+	
+	return cfg, nil
+}
+
+// resolvePattern expands ~ and makes relative patterns relative to the
+// directory of the config file that declared them.
+func resolvePattern(baseDir, pattern string) (string, error) {
+	if pattern == "" {
+		return "", errors.New("override is missing a path")
+	}
+	
+	if strings.HasPrefix(pattern, "~") {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		pattern = filepath.Join(homeDir, pattern[1:])
+	} else if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+	
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for %s: %w", pattern, err)
+	}
+	
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return "", fmt.Errorf("bad path pattern %q: %w", pattern, err)
+	}
+	
+	return pattern, nil
+}
+
+// Merge layers other on top of c. Values set in other win and overrides are
+// appended so that later files take precedence when matching.
+func (c *Config) Merge(other *Config) {
+	if other.Key != nil {
+		c.Key = other.Key
+	}
+	if other.Branches != nil {
+		c.Branches = other.Branches
+	}
+	if other.BranchesRegex != nil {
+		c.BranchesRegex = other.BranchesRegex
+	}
+	if other.FallbackBranch != nil {
+		c.FallbackBranch = other.FallbackBranch
+	}
+	if other.Concurrent != nil {
+		c.Concurrent = other.Concurrent
+	}
+	if other.ScanConcurrent != nil {
+		c.ScanConcurrent = other.ScanConcurrent
+	}
+	if other.PullConcurrent != nil {
+		c.PullConcurrent = other.PullConcurrent
+	}
+	if other.PerHost != nil {
+		c.PerHost = other.PerHost
+	}
+	if other.Verbose != nil {
+		c.Verbose = other.Verbose
+	}
+	if other.Quiet != nil {
+		c.Quiet = other.Quiet
+	}
+	if other.LogLevel != nil {
+		c.LogLevel = other.LogLevel
+	}
+	if other.Color != nil {
+		c.Color = other.Color
+	}
+	if other.LogFile != nil {
+		c.LogFile = other.LogFile
+	}
+	if other.Path != nil {
+		c.Path = other.Path
+	}
+	if other.ReposFrom != nil {
+		c.ReposFrom = other.ReposFrom
+	}
+	if other.DryRun != nil {
+		c.DryRun = other.DryRun
+	}
+	if other.Stash != nil {
+		c.Stash = other.Stash
+	}
+	if other.FetchOnly != nil {
+		c.FetchOnly = other.FetchOnly
+	}
+	if other.Submodules != nil {
+		c.Submodules = other.Submodules
+	}
+	if other.FFOnly != nil {
+		c.FFOnly = other.FFOnly
+	}
+	if other.ForceBranch != nil {
+		c.ForceBranch = other.ForceBranch
+	}
+	if other.CurrentBranch != nil {
+		c.CurrentBranch = other.CurrentBranch
+	}
+	if other.AllBranches != nil {
+		c.AllBranches = other.AllBranches
+	}
+	if other.Tags != nil {
+		c.Tags = other.Tags
+	}
+	if other.NoTags != nil {
+		c.NoTags = other.NoTags
+	}
+	if other.PreHook != nil {
+		c.PreHook = other.PreHook
+	}
+	if other.PostHook != nil {
+		c.PostHook = other.PostHook
+	}
+	if other.IgnoreHookErrors != nil {
+		c.IgnoreHookErrors = other.IgnoreHookErrors
+	}
+	if other.Prune != nil {
+		c.Prune = other.Prune
+	}
+	if other.GC != nil {
+		c.GC = other.GC
+	}
+	if other.GCInterval != nil {
+		c.GCInterval = other.GCInterval
+	}
+	if other.Unshallow != nil {
+		c.Unshallow = other.Unshallow
+	}
+	if other.VerifyClean != nil {
+		c.VerifyClean = other.VerifyClean
+	}
+	if other.CheckConflicts != nil {
+		c.CheckConflicts = other.CheckConflicts
+	}
+	if other.Stats != nil {
+		c.Stats = other.Stats
+	}
+	if other.NameFromRemote != nil {
+		c.NameFromRemote = other.NameFromRemote
+	}
+	if other.Strict != nil {
+		c.Strict = other.Strict
+	}
+	if other.FailFast != nil {
+		c.FailFast = other.FailFast
+	}
+	if other.Confirm != nil {
+		c.Confirm = other.Confirm
+	}
+	if other.ConfirmAbove != nil {
+		c.ConfirmAbove = other.ConfirmAbove
+	}
+	if other.Yes != nil {
+		c.Yes = other.Yes
+	}
+	if other.CredentialHelper != nil {
+		c.CredentialHelper = other.CredentialHelper
+	}
+	if other.Backend != nil {
+		c.Backend = other.Backend
+	}
+	if other.Report != nil {
+		c.Report = other.Report
+	}
+	if other.UseKeychain != nil {
+		c.UseKeychain = other.UseKeychain
+	}
+	if other.GitConfig != nil {
+		c.GitConfig = other.GitConfig
+	}
+	if other.Timeout != nil {
+		c.Timeout = other.Timeout
+	}
+	if other.MaxRuntime != nil {
+		c.MaxRuntime = other.MaxRuntime
+	}
+	if other.Wait != nil {
+		c.Wait = other.Wait
+	}
+	if other.Watch != nil {
+		c.Watch = other.Watch
+	}
+	if other.Rescan != nil {
+		c.Rescan = other.Rescan
+	}
+	if other.MetricsFile != nil {
+		c.MetricsFile = other.MetricsFile
+	}
+	if other.Exclude != nil {
+		c.Exclude = other.Exclude
+	}
+	if other.Match != nil {
+		c.Match = other.Match
+	}
+	if other.MaxDepth != nil {
+		c.MaxDepth = other.MaxDepth
+	}
+	if other.SkipDir != nil {
+		c.SkipDir = other.SkipDir
+	}
+	if other.NoDefaultSkip != nil {
+		c.NoDefaultSkip = other.NoDefaultSkip
+	}
+	if other.SkipHidden != nil {
+		c.SkipHidden = other.SkipHidden
+	}
+	if other.FollowSymlinks != nil {
+		c.FollowSymlinks = other.FollowSymlinks
+	}
+	if other.Nested != nil {
+		c.Nested = other.Nested
+	}
+	if other.Worktrees != nil {
+		c.Worktrees = other.Worktrees
+	}
+	if other.RemoteFilter != nil {
+		c.RemoteFilter = other.RemoteFilter
+	}
+	if other.Remote != nil {
+		c.Remote = other.Remote
+	}
+	if other.Since != nil {
+		c.Since = other.Since
+	}
+	if other.MaxRepos != nil {
+		c.MaxRepos = other.MaxRepos
+	}
+	if other.MaxDiscovered != nil {
+		c.MaxDiscovered = other.MaxDiscovered
+	}
+	if other.OpenRequests != nil {
+		c.OpenRequests = other.OpenRequests
+	}
+	if other.Notify != nil {
+		c.Notify = other.Notify
+	}
+	if other.StateFile != nil {
+		c.StateFile = other.StateFile
+	}
+	if other.Output != nil {
+		c.Output = other.Output
+	}
+	if other.Template != nil {
+		c.Template = other.Template
+	}
+	if other.Events != nil {
+		c.Events = other.Events
+	}
+	c.Overrides = append(c.Overrides, other.Overrides...)
+}
+
+// FlagValues returns the values that are set, keyed by flag name and
+// formatted the way flag.Set expects them.
+func (c *Config) FlagValues() map[string]string {
+	values := make(map[string]string)
+	
+	if c.Key != nil {
+		values["key"] = strings.Join(c.Key, ",")
+	}
+	if c.Branches != nil {
+		values["branches"] = strings.Join(c.Branches, ",")
+	}
+	if c.BranchesRegex != nil {
+		values["branches-regex"] = *c.BranchesRegex
+	}
+	if c.FallbackBranch != nil {
+		values["default-branch-fallback"] = *c.FallbackBranch
+	}
+	if c.Concurrent != nil {
+		values["concurrent"] = strconv.Itoa(*c.Concurrent)
+	}
+	if c.ScanConcurrent != nil {
+		values["scan-concurrent"] = strconv.Itoa(*c.ScanConcurrent)
+	}
+	if c.PullConcurrent != nil {
+		values["pull-concurrent"] = strconv.Itoa(*c.PullConcurrent)
+	}
+	if c.PerHost != nil {
+		values["concurrent-per-host"] = strconv.Itoa(*c.PerHost)
+	}
+	if c.Verbose != nil {
+		values["verbose"] = strconv.FormatBool(*c.Verbose)
+	}
+	if c.Quiet != nil {
+		values["quiet"] = strconv.FormatBool(*c.Quiet)
+	}
+	if c.LogLevel != nil {
+		values["log-level"] = *c.LogLevel
+	}
+	if c.Color != nil {
+		values["color"] = *c.Color
+	}
+	if c.LogFile != nil {
+		values["log-file"] = *c.LogFile
+	}
+	if c.Path != nil {
+		values["path"] = *c.Path
+	}
+	if c.ReposFrom != nil {
+		values["repos-from"] = *c.ReposFrom
+	}
+	if c.DryRun != nil {
+		values["dry-run"] = strconv.FormatBool(*c.DryRun)
+	}
+	if c.Stash != nil {
+		values["stash"] = strconv.FormatBool(*c.Stash)
+	}
+	if c.FetchOnly != nil {
+		values["fetch-only"] = strconv.FormatBool(*c.FetchOnly)
+	}
+	if c.Submodules != nil {
+		values["submodules"] = strconv.FormatBool(*c.Submodules)
+	}
+	if c.FFOnly != nil {
+		values["ff-only"] = strconv.FormatBool(*c.FFOnly)
+	}
+	if c.ForceBranch != nil {
+		values["force-branch"] = strconv.FormatBool(*c.ForceBranch)
+	}
+	if c.CurrentBranch != nil {
+		values["current-branch"] = strconv.FormatBool(*c.CurrentBranch)
+	}
+	if c.AllBranches != nil {
+		values["all-branches"] = strconv.FormatBool(*c.AllBranches)
+	}
+	if c.Tags != nil {
+		values["tags"] = strconv.FormatBool(*c.Tags)
+	}
+	if c.NoTags != nil {
+		values["no-tags"] = strconv.FormatBool(*c.NoTags)
+	}
+	if c.PreHook != nil {
+		values["pre-hook"] = *c.PreHook
+	}
+	if c.PostHook != nil {
+		values["post-hook"] = *c.PostHook
+	}
+	if c.IgnoreHookErrors != nil {
+		values["ignore-hook-errors"] = strconv.FormatBool(*c.IgnoreHookErrors)
+	}
+	if c.Prune != nil {
+		values["prune"] = strconv.FormatBool(*c.Prune)
+	}
+	if c.GC != nil {
+		values["gc"] = strconv.FormatBool(*c.GC)
+	}
+	if c.GCInterval != nil {
+		values["gc-interval"] = *c.GCInterval
+	}
+	if c.Unshallow != nil {
+		values["unshallow"] = strconv.FormatBool(*c.Unshallow)
+	}
+	if c.VerifyClean != nil {
+		values["verify-clean"] = *c.VerifyClean
+	}
+	if c.CheckConflicts != nil {
+		values["check-conflicts"] = strconv.FormatBool(*c.CheckConflicts)
+	}
+	if c.Stats != nil {
+		values["stats"] = strconv.FormatBool(*c.Stats)
+	}
+	if c.NameFromRemote != nil {
+		values["name-from-remote"] = strconv.FormatBool(*c.NameFromRemote)
+	}
+	if c.Strict != nil {
+		values["strict"] = strconv.FormatBool(*c.Strict)
+	}
+	if c.FailFast != nil {
+		values["fail-fast"] = strconv.FormatBool(*c.FailFast)
+	}
+	if c.Confirm != nil {
+		values["confirm"] = strconv.FormatBool(*c.Confirm)
+	}
+	if c.ConfirmAbove != nil {
+		values["confirm-above"] = strconv.Itoa(*c.ConfirmAbove)
+	}
+	if c.Yes != nil {
+		values["yes"] = strconv.FormatBool(*c.Yes)
+	}
+	if c.CredentialHelper != nil {
+		values["credential-helper"] = *c.CredentialHelper
+	}
+	if c.Backend != nil {
+		values["backend"] = *c.Backend
+	}
+	if c.Report != nil {
+		values["report"] = *c.Report
+	}
+	if c.UseKeychain != nil {
+		values["use-keychain"] = strconv.FormatBool(*c.UseKeychain)
+	}
+	if c.GitConfig != nil {
+		values["git-config"] = strings.Join(c.GitConfig, "\n")
+	}
+	if c.Timeout != nil {
+		values["timeout"] = c.Timeout.String()
+	}
+	if c.MaxRuntime != nil {
+		values["max-runtime"] = c.MaxRuntime.String()
+	}
+	if c.Wait != nil {
+		values["wait"] = strconv.FormatBool(*c.Wait)
+	}
+	if c.Watch != nil {
+		values["watch"] = c.Watch.String()
+	}
+	if c.Rescan != nil {
+		values["rescan"] = c.Rescan.String()
+	}
+	if c.MetricsFile != nil {
+		values["metrics-file"] = *c.MetricsFile
+	}
+	if c.Exclude != nil {
+		values["exclude"] = strings.Join(c.Exclude, ",")
+	}
+	if c.Match != nil {
+		values["match"] = strings.Join(c.Match, ",")
+	}
+	if c.MaxDepth != nil {
+		values["max-depth"] = strconv.Itoa(*c.MaxDepth)
+	}
+	if c.SkipDir != nil {
+		values["skip-dir"] = strings.Join(c.SkipDir, ",")
+	}
+	if c.NoDefaultSkip != nil {
+		values["no-default-skip-dirs"] = strconv.FormatBool(*c.NoDefaultSkip)
+	}
+	if c.SkipHidden != nil {
+		values["skip-hidden"] = strconv.FormatBool(*c.SkipHidden)
+	}
+	if c.FollowSymlinks != nil {
+		values["follow-symlinks"] = strconv.FormatBool(*c.FollowSymlinks)
+	}
+	if c.Nested != nil {
+		values["nested"] = strconv.FormatBool(*c.Nested)
+	}
+	if c.Worktrees != nil {
+		values["worktrees"] = strconv.FormatBool(*c.Worktrees)
+	}
+	if c.MaxRepos != nil {
+		values["max-repos"] = strconv.Itoa(*c.MaxRepos)
+	}
+	if c.MaxDiscovered != nil {
+		values["max-discovered"] = strconv.Itoa(*c.MaxDiscovered)
+	}
+	if c.RemoteFilter != nil {
+		values["remote-filter"] = *c.RemoteFilter
+	}
+	if c.Remote != nil {
+		values["remote"] = *c.Remote
+	}
+	if c.Since != nil {
+		values["since"] = *c.Since
+	}
+	if c.OpenRequests != nil {
+		values["open-requests"] = strconv.FormatBool(*c.OpenRequests)
+	}
+	if c.Notify != nil {
+		values["notify"] = strconv.FormatBool(*c.Notify)
+	}
+	if c.StateFile != nil {
+		values["state-file"] = *c.StateFile
+	}
+	if c.Output != nil {
+		values["output"] = *c.Output
+	}
+	if c.Template != nil {
+		values["template"] = *c.Template
+	}
+	if c.Events != nil {
+		values["events"] = *c.Events
+	}
+	
+	return values
+}
+
+// OverridesFor returns the overrides whose path pattern matches repoPath, in
+// the order they should be applied.
+func (c *Config) OverridesFor(repoPath string) []Override {
+	var matched []Override
+	for _, o := range c.Overrides {
+		if ok, _ := filepath.Match(o.Path, repoPath); ok {
+			matched = append(matched, o)
+		}
+	}
+	
+	return matched
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Emitter writes events as newline-delimited JSON, one object per line,
+// each written in a single call so a reader sees whole lines as they happen.
+// A nil *Emitter discards events.
+type Emitter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewEmitter(w io.Writer) *Emitter {
+	return &Emitter{w: w}
+}
+
+// Open returns an Emitter for dest, which is "-" for stdout, "unix:PATH" for
+// a Unix socket to connect to, or the path of a file or named pipe. The
+// destination stays open until the process exits.
+func Open(dest string) (*Emitter, error) {
+	if dest == "-" {
+		return NewEmitter(os.Stdout), nil
+	}
+	
+	if socketPath, ok := strings.CutPrefix(dest, "unix:"); ok {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", socketPath, err)
+		}
+		return NewEmitter(conn), nil
+	}
+	
+	f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", dest, err)
+	}
+	return NewEmitter(f), nil
+}
+
+// Emit writes an event named name. The fields of data, which must encode to
+// a JSON object, are added after the event name and the time.
+func (e *Emitter) Emit(name string, data any) {
+	if e == nil {
+		return
+	}
+	
+	head, err := json.Marshal(struct {
+		Event string    `json:"event"`
+		Time  time.Time `json:"time"`
+	}{name, time.Now()})
+	if err != nil {
+		return
+	}
+	
+	line := head
+	if data != nil {
+		fields, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		// Splice the two objects together to keep "event" first.
+		fields = bytes.TrimPrefix(fields, []byte("{"))
+		if !bytes.Equal(fields, []byte("}")) {
+			line = append(append(head[:len(head)-1:len(head)-1], ','), fields...)
+		}
+	}
+	line = append(line, '\n')
+	
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(line)
+}
+
+type emitterKey struct{}
+
+// WithEmitter returns a copy of ctx that carries e.
+func WithEmitter(ctx context.Context, e *Emitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, e)
+}
+
+// FromContext returns the Emitter carried by ctx, or nil if there is none so
+// that events are discarded.
+func FromContext(ctx context.Context) *Emitter {
+	e, _ := ctx.Value(emitterKey{}).(*Emitter)
+	return e
+}
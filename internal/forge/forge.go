@@ -0,0 +1,139 @@
+package forge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
+)
+
+// HTTPClient is used for all API requests.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrUnsupported is returned when the remote is not on a known forge or no
+// token is available for it.
+var ErrUnsupported = errors.New("no supported forge for remote")
+
+// Project identifies a repository on a forge.
+type Project struct {
+	Host string
+	// Path is the owner and name, e.g. "golang/go", or the full group path
+	// on GitLab.
+	Path string
+}
+
+// ParseProject extracts the host and project path from a remote URL in URL
+// or scp-like form.
+func ParseProject(remoteURL string) (Project, error) {
+	host, owner, repo, err := gitmanager.ParseRemoteURL(remoteURL)
+	if err != nil {
+		return Project{}, err
+	}
+	
+	return Project{Host: host, Path: owner + "/" + repo}, nil
+}
+
+// OpenRequests returns the number of open pull requests, or merge requests on
+// GitLab, of the repository behind remoteURL. GitHub is used for github.com
+// and the host in GITHUB_HOST with the token in GITHUB_TOKEN or GH_TOKEN,
+// GitLab for gitlab.com and the host in GITLAB_HOST with the token in
+// GITLAB_TOKEN. Other remotes return ErrUnsupported.
+func OpenRequests(ctx context.Context, remoteURL string) (int, error) {
+	project, err := ParseProject(remoteURL)
+	if err != nil {
+		return 0, err
+	}
+	
+	switch {
+	case project.Host == "github.com" || project.Host == strings.ToLower(os.Getenv("GITHUB_HOST")):
+		token := os.Getenv("GITHUB_TOKEN")
+		if token == "" {
+			token = os.Getenv("GH_TOKEN")
+		}
+		if token == "" {
+			return 0, ErrUnsupported
+		}
+		return githubPullRequests(ctx, project, token)
+	case project.Host == "gitlab.com" || project.Host == strings.ToLower(os.Getenv("GITLAB_HOST")):
+		token := os.Getenv("GITLAB_TOKEN")
+		if token == "" {
+			return 0, ErrUnsupported
+		}
+		return gitlabMergeRequests(ctx, project, token)
+	}
+	
+	return 0, ErrUnsupported
+}
+
+// lastPagePattern finds the last page in a GitHub Link header.
+var lastPagePattern = regexp.MustCompile(`[?&]page=(\d+)[^>]*>;\s*rel="last"`)
+
+func githubPullRequests(ctx context.Context, project Project, token string) (int, error) {
+	apiURL := "https://api.github.com"
+	if project.Host != "github.com" {
+		apiURL = "https://" + project.Host + "/api/v3"
+	}
+	
+	// With one pull request per page the number of the last page is the
+	// total, so a single request is enough.
+	resp, err := get(ctx, apiURL+"/repos/"+project.Path+"/pulls?state=open&per_page=1", "Authorization", "Bearer "+token)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	
+	if m := lastPagePattern.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+		return strconv.Atoi(m[1])
+	}
+	
+	var pulls []json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&pulls); err != nil {
+		return 0, fmt.Errorf("failed to decode GitHub response: %w", err)
+	}
+	return len(pulls), nil
+}
+
+func gitlabMergeRequests(ctx context.Context, project Project, token string) (int, error) {
+	apiURL := "https://" + project.Host + "/api/v4/projects/" + url.PathEscape(project.Path) + "/merge_requests?state=opened&per_page=1"
+	resp, err := get(ctx, apiURL, "PRIVATE-TOKEN", token)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	
+	total := resp.Header.Get("X-Total")
+	if total == "" {
+		return 0, errors.New("GitLab response has no X-Total header")
+	}
+	return strconv.Atoi(total)
+}
+
+// get sends a GET request authenticated by the given header and fails on any
+// status other than 200 OK.
+func get(ctx context.Context, apiURL, header, value string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(header, value)
+	
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", apiURL, resp.Status)
+	}
+	
+	return resp, nil
+}
@@ -0,0 +1,157 @@
+package gitmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The errors below describe why a repository could not be updated. They are
+// stored in RepoResult.Err, possibly wrapped, so use errors.Is to check them.
+var (
+	ErrNotFound      = errors.New("directory does not exist")
+	ErrNotGitRepo    = errors.New("not a git repository")
+	ErrNoRemote      = errors.New("remote not found")
+	ErrEmptyRepo     = errors.New("repository has no commits")
+	ErrDetachedHead  = errors.New("detached HEAD")
+	ErrDirty         = errors.New("uncommitted changes")
+	ErrInProgress    = errors.New("operation in progress")
+	ErrBecameDirty   = errors.New("working tree changed by the update")
+	ErrMergeConflict = errors.New("merge conflict")
+	ErrAuthFailed    = errors.New("authentication failed")
+	ErrNetwork       = errors.New("network error")
+	ErrHookFailed    = errors.New("hook failed")
+)
+
+// ErrDiverged is returned by Pull in fast-forward-only mode when the local
+// branch has commits that are not on the remote.
+var ErrDiverged = errors.New("cannot fast-forward, diverged from the remote")
+
+// ErrConflictMarkers is set when CheckConflicts finds merge conflict markers
+// in the files of a repository that was otherwise pulled successfully.
+var ErrConflictMarkers = errors.New("conflict markers left in files")
+
+// ErrNoCredentials is returned by Pull and Fetch when an HTTPS remote needs
+// credentials and none are available without prompting.
+var ErrNoCredentials = fmt.Errorf("%w: no credentials available for HTTPS remote", ErrAuthFailed)
+
+// ErrTimeout is returned when a git command runs longer than the timeout set
+// in Options.
+var ErrTimeout = errors.New("operation timed out")
+
+// Reason is the category of a failed or skipped repository.
+type Reason string
+
+const (
+	ReasonNotFound      Reason = "not_found"
+	ReasonNotGitRepo    Reason = "not_git_repo"
+	ReasonNoRemote      Reason = "no_remote"
+	ReasonEmpty         Reason = "empty"
+	ReasonDetachedHead  Reason = "detached_head"
+	ReasonDirty         Reason = "dirty"
+	ReasonInProgress    Reason = "in_progress"
+	ReasonBecameDirty   Reason = "became_dirty"
+	ReasonDiverged      Reason = "diverged"
+	ReasonMergeConflict Reason = "merge_conflict"
+	ReasonAuthFailed    Reason = "auth_failed"
+	ReasonNetwork       Reason = "network"
+	ReasonTimeout       Reason = "timeout"
+	ReasonHookFailed    Reason = "hook_failed"
+	ReasonCancelled     Reason = "cancelled"
+	ReasonFiltered      Reason = "filtered"
+	ReasonUnmatched     Reason = "unmatched"
+	ReasonStale         Reason = "stale"
+	// ReasonConflictMarkers is the category of ErrConflictMarkers.
+	ReasonConflictMarkers Reason = "conflict_markers"
+	// ReasonGitError covers git failures that fit no other category.
+	ReasonGitError Reason = "git_error"
+)
+
+// reasons maps the errors above to their category, checked in order.
+var reasons = []struct {
+	err    error
+	reason Reason
+}{
+	{context.Canceled, ReasonCancelled},
+	{ErrHookFailed, ReasonHookFailed},
+	{ErrTimeout, ReasonTimeout},
+	{ErrNotFound, ReasonNotFound},
+	{ErrNotGitRepo, ReasonNotGitRepo},
+	{ErrNoRemote, ReasonNoRemote},
+	{ErrEmptyRepo, ReasonEmpty},
+	{ErrDetachedHead, ReasonDetachedHead},
+	{ErrDirty, ReasonDirty},
+	{ErrInProgress, ReasonInProgress},
+	{ErrBecameDirty, ReasonBecameDirty},
+	{ErrDiverged, ReasonDiverged},
+	{ErrMergeConflict, ReasonMergeConflict},
+	{ErrConflictMarkers, ReasonConflictMarkers},
+	{ErrAuthFailed, ReasonAuthFailed},
+	{ErrNetwork, ReasonNetwork},
+}
+
+// ReasonFor returns the category of err, or an empty Reason for nil.
+func ReasonFor(err error) Reason {
+	if err == nil {
+		return ""
+	}
+	
+	for _, r := range reasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	
+	return ReasonGitError
+}
+
+// outputErrors maps messages git prints on failure to the error they stand
+// for.
+var outputErrors = []struct {
+	err      error
+	messages []string
+}{
+	{ErrAuthFailed, []string{
+		"Authentication failed",
+		"Permission denied (publickey",
+		"Host key verification failed",
+		"terminal prompts disabled",
+		"could not read Username",
+		"could not read Password",
+		"The requested URL returned error: 401",
+		"The requested URL returned error: 403",
+		"ssh: unable to authenticate",
+	}},
+	{ErrNetwork, []string{
+		"Could not resolve host",
+		"Could not resolve hostname",
+		"Failed to connect to",
+		"Connection refused",
+		"Connection timed out",
+		"Connection reset",
+		"Network is unreachable",
+		"Operation timed out",
+		"The remote end hung up unexpectedly",
+	}},
+	{ErrMergeConflict, []string{
+		"CONFLICT (",
+		"Automatic merge failed",
+		"You have unmerged paths",
+		"would be overwritten by merge",
+	}},
+}
+
+// classifyOutput returns the error described by the output of a failed git
+// command, or nil when it is not recognized.
+func classifyOutput(output string) error {
+	for _, o := range outputErrors {
+		for _, message := range o.messages {
+			if strings.Contains(output, message) {
+				return o.err
+			}
+		}
+	}
+	
+	return nil
+}
@@ -0,0 +1,224 @@
+package gitmanager
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Git is the set of operations ProcessRepository performs on a repository.
+// ExecGit runs the git binary; tests and other backends can provide their
+// own implementation.
+type Git interface {
+	IsGitRepo(ctx context.Context, dir string) bool
+	// LastActivity returns the later of the time of the HEAD commit and the
+	// time HEAD was last moved, for example by a checkout.
+	LastActivity(ctx context.Context, dir string) (time.Time, error)
+	// RemoteURL returns the URL of the remote set with WithRemote.
+	RemoteURL(ctx context.Context, dir string) (string, error)
+	// HasSSHCommand reports whether the repository sets core.sshCommand,
+	// in which case the agent may not be needed for it.
+	HasSSHCommand(ctx context.Context, dir string) bool
+	// CommonDir returns the git directory shared by all worktrees.
+	CommonDir(ctx context.Context, dir string) (string, error)
+	IsShallow(ctx context.Context, dir string) bool
+	Unshallow(ctx context.Context, dir string, opts Options) error
+	// InProgressOperation returns the name of an unfinished operation, such
+	// as "rebase" or "merge".
+	InProgressOperation(ctx context.Context, dir string) (string, bool)
+	// DefaultBranch detects the default branch as DetectDefaultBranch does.
+	DefaultBranch(ctx context.Context, dir string, fallbacks []string, pattern *regexp.Regexp) (string, error)
+	// RefreshDefaultBranch asks the remote for its default branch again and
+	// records it as the remote's HEAD, for when it was renamed.
+	RefreshDefaultBranch(ctx context.Context, dir string, opts Options) (string, error)
+	CurrentBranch(ctx context.Context, dir string) (string, error)
+	UpstreamExists(ctx context.Context, dir, branch string) bool
+	IsDetachedHead(ctx context.Context, dir string) bool
+	IsWorkingTreeClean(ctx context.Context, dir string) bool
+	ModifiedFiles(ctx context.Context, dir string) ([]string, error)
+	StashPush(ctx context.Context, dir string) error
+	StashPop(ctx context.Context, dir string) error
+	Checkout(ctx context.Context, dir, branch string) error
+	HeadCommit(ctx context.Context, dir string) (string, error)
+	// ResetHard points the checked out branch at ref, such as origin/main,
+	// and makes the working tree match it.
+	ResetHard(ctx context.Context, dir, ref string) error
+	Fetch(ctx context.Context, dir string, opts Options) (Transfer, error)
+	Pull(ctx context.Context, dir string, opts Options) (Transfer, error)
+	// Merge brings the checked out branch up to date with its upstream as
+	// it was last fetched, without contacting the remote.
+	Merge(ctx context.Context, dir string, opts Options) error
+	UpdateSubmodules(ctx context.Context, dir string) error
+	ListTags(ctx context.Context, dir string) (map[string]string, error)
+	AheadBehind(ctx context.Context, dir, branch string) (ahead, behind int, err error)
+	TrackingBranches(ctx context.Context, dir string) ([]TrackingBranch, error)
+	// LocalBranches returns all local branches, with an empty Upstream for
+	// the ones without one.
+	LocalBranches(ctx context.Context, dir string) ([]TrackingBranch, error)
+	// CountUnpushed counts the commits on b that are not on its upstream,
+	// or on any remote when it has none.
+	CountUnpushed(ctx context.Context, dir string, b TrackingBranch) (int, error)
+	FastForwardBranch(ctx context.Context, dir string, b TrackingBranch) (bool, error)
+	PruneRemote(ctx context.Context, dir string, opts Options) (int, error)
+	// ConflictFiles returns the tracked files that contain merge conflict
+	// markers.
+	ConflictFiles(ctx context.Context, dir string) ([]string, error)
+	// Maintenance packs loose objects and cleans up the repository.
+	Maintenance(ctx context.Context, dir string) error
+	// Fsck returns the problems found checking the repository for
+	// corruption, none for a healthy one.
+	Fsck(ctx context.Context, dir string) ([]string, error)
+}
+
+// NewBackend returns the Git implementation called name: "git" for ExecGit
+// or "go-git" for GoGit.
+func NewBackend(name string) (Git, error) {
+	switch name {
+	case "git":
+		return ExecGit{}, nil
+	case "go-git":
+		return GoGit{}, nil
+	}
+	
+	return nil, fmt.Errorf("unknown backend %q, expected git or go-git", name)
+}
+
+// ExecGit implements Git by running the git binary through ExecCommand.
+type ExecGit struct{}
+
+func (ExecGit) IsGitRepo(ctx context.Context, dir string) bool {
+	return IsGitRepo(ctx, dir)
+}
+
+func (ExecGit) LastActivity(ctx context.Context, dir string) (time.Time, error) {
+	return LastActivity(ctx, dir)
+}
+
+func (ExecGit) RemoteURL(ctx context.Context, dir string) (string, error) {
+	return GetRemoteURL(ctx, dir)
+}
+
+func (ExecGit) HasSSHCommand(ctx context.Context, dir string) bool {
+	return HasSSHCommand(ctx, dir)
+}
+
+func (ExecGit) CommonDir(ctx context.Context, dir string) (string, error) {
+	return CommonDir(ctx, dir)
+}
+
+func (ExecGit) IsShallow(ctx context.Context, dir string) bool {
+	return IsShallow(ctx, dir)
+}
+
+func (ExecGit) Unshallow(ctx context.Context, dir string, opts Options) error {
+	return Unshallow(ctx, dir, opts)
+}
+
+func (ExecGit) InProgressOperation(ctx context.Context, dir string) (string, bool) {
+	return InProgressOperation(ctx, dir)
+}
+
+func (ExecGit) DefaultBranch(ctx context.Context, dir string, fallbacks []string, pattern *regexp.Regexp) (string, error) {
+	return DetectDefaultBranch(ctx, dir, fallbacks, pattern)
+}
+
+func (ExecGit) RefreshDefaultBranch(ctx context.Context, dir string, opts Options) (string, error) {
+	return RefreshDefaultBranch(ctx, dir, opts)
+}
+
+func (ExecGit) CurrentBranch(ctx context.Context, dir string) (string, error) {
+	return CurrentBranch(ctx, dir)
+}
+
+func (ExecGit) UpstreamExists(ctx context.Context, dir, branch string) bool {
+	return UpstreamExists(ctx, dir, branch)
+}
+
+func (ExecGit) IsDetachedHead(ctx context.Context, dir string) bool {
+	return IsDetachedHead(ctx, dir)
+}
+
+func (ExecGit) IsWorkingTreeClean(ctx context.Context, dir string) bool {
+	return IsWorkingTreeClean(ctx, dir)
+}
+
+func (ExecGit) ModifiedFiles(ctx context.Context, dir string) ([]string, error) {
+	return ModifiedFiles(ctx, dir)
+}
+
+func (ExecGit) StashPush(ctx context.Context, dir string) error {
+	return StashPush(ctx, dir)
+}
+
+func (ExecGit) StashPop(ctx context.Context, dir string) error {
+	return StashPop(ctx, dir)
+}
+
+func (ExecGit) Checkout(ctx context.Context, dir, branch string) error {
+	return CheckoutBranch(ctx, dir, branch)
+}
+
+func (ExecGit) HeadCommit(ctx context.Context, dir string) (string, error) {
+	return HeadCommit(ctx, dir)
+}
+
+func (ExecGit) ResetHard(ctx context.Context, dir, ref string) error {
+	return ResetHard(ctx, dir, ref)
+}
+
+func (ExecGit) Fetch(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	return Fetch(ctx, dir, opts)
+}
+
+func (ExecGit) Pull(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	return Pull(ctx, dir, opts)
+}
+
+func (ExecGit) Merge(ctx context.Context, dir string, opts Options) error {
+	return MergeUpstream(ctx, dir, opts)
+}
+
+func (ExecGit) UpdateSubmodules(ctx context.Context, dir string) error {
+	return UpdateSubmodules(ctx, dir)
+}
+
+func (ExecGit) ListTags(ctx context.Context, dir string) (map[string]string, error) {
+	return ListTags(ctx, dir)
+}
+
+func (ExecGit) AheadBehind(ctx context.Context, dir, branch string) (int, int, error) {
+	return AheadBehind(ctx, dir, branch)
+}
+
+func (ExecGit) TrackingBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	return TrackingBranches(ctx, dir)
+}
+
+func (ExecGit) LocalBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	return LocalBranches(ctx, dir)
+}
+
+func (ExecGit) CountUnpushed(ctx context.Context, dir string, b TrackingBranch) (int, error) {
+	return CountUnpushed(ctx, dir, b)
+}
+
+func (ExecGit) FastForwardBranch(ctx context.Context, dir string, b TrackingBranch) (bool, error) {
+	return FastForwardBranch(ctx, dir, b)
+}
+
+func (ExecGit) PruneRemote(ctx context.Context, dir string, opts Options) (int, error) {
+	return PruneRemote(ctx, dir, opts)
+}
+
+func (ExecGit) ConflictFiles(ctx context.Context, dir string) ([]string, error) {
+	return ConflictFiles(ctx, dir)
+}
+
+func (ExecGit) Maintenance(ctx context.Context, dir string) error {
+	return Maintenance(ctx, dir)
+}
+
+func (ExecGit) Fsck(ctx context.Context, dir string) ([]string, error) {
+	return Fsck(ctx, dir)
+}
@@ -1,68 +1,614 @@
 package gitmanager
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/lyubomir-bozhinov/pullio/internal/events"
 	"github.com/lyubomir-bozhinov/pullio/internal/logger"
 )
 
-var ExecCommand = exec.Command
+var ExecCommand = exec.CommandContext
+
+// Options controls how ProcessRepository updates a repository.
+type Options struct {
+	DefaultBranches []string
+	// DefaultBranchPattern is tried against the local branches when none of
+	// DefaultBranches exists.
+	DefaultBranchPattern *regexp.Regexp
+	// FallbackBranch is checked out and pulled as a last resort when the
+	// default branch cannot be detected at all, such as in a fresh clone
+	// without the remote's HEAD. Empty fails the repository instead.
+	FallbackBranch string
+	// DryRun detects what would be updated without checking out or pulling.
+	DryRun bool
+	// Stash stashes uncommitted changes before updating and restores them
+	// afterwards instead of skipping dirty repositories.
+	Stash bool
+	// FetchOnly refreshes remote-tracking refs without touching the working
+	// tree or the checked out branch.
+	FetchOnly bool
+	// Submodules initializes and updates submodules after a successful pull.
+	Submodules bool
+	// FFOnly refuses to pull when the branch cannot be fast-forwarded.
+	FFOnly bool
+	// ResetHard resets a branch that cannot be fast-forwarded to the same
+	// branch on the remote, for clones that are only ever read. It implies
+	// FFOnly. Branches that had commits the remote did not are left alone
+	// unless ResetForce is set too.
+	ResetHard  bool
+	ResetForce bool
+	// CredentialHelper is an additional git credential helper used for
+	// HTTPS remotes.
+	CredentialHelper string
+	// SSHKey, if set, is the only key ssh offers for SSH remotes, in place
+	// of the keys in the agent and the repository's core.sshCommand.
+	SSHKey string
+	// ForceBranch checks out the default branch even when HEAD is detached
+	// instead of skipping the repository.
+	ForceBranch bool
+	// CurrentBranch pulls the checked out branch when it has an upstream
+	// instead of switching to the default branch.
+	CurrentBranch bool
+	// AllBranches also fast-forwards every other local branch that has an
+	// upstream, without checking it out.
+	AllBranches bool
+	// Tags also fetches all tags from the remote, replacing local tags that
+	// were moved there. NoTags fetches no tags at all, not even the ones
+	// pointing at fetched commits. With neither, the tags of fetched commits
+	// are followed, as git does by default, even for a remote whose tagOpt
+	// setting says otherwise.
+	Tags   bool
+	NoTags bool
+	// PreHook is a shell command run in the repository before checking out
+	// and pulling.
+	PreHook string
+	// PostHook is a shell command run in the repository after a pull that
+	// brought in new commits.
+	PostHook string
+	// IgnoreHookErrors records hook failures without failing the repository.
+	IgnoreHookErrors bool
+	// VerifyClean checks that a clean working tree is still clean after the
+	// pull. With "warn" changed files are reported, with "fail" they also
+	// fail the repository. Empty means no check.
+	VerifyClean string
+	// CheckConflicts fails a repository whose tracked files contain merge
+	// conflict markers after the pull, left behind by a merge that was
+	// committed without resolving them.
+	CheckConflicts bool
+	// Stats counts the objects and bytes received from the remote.
+	Stats bool
+	// Unshallow fetches the full history of shallow clones before updating
+	// them.
+	Unshallow bool
+	// Prune removes remote-tracking branches that no longer exist on origin
+	// after a successful pull.
+	Prune bool
+	// GC runs git maintenance after a successful pull, to pack loose
+	// objects and keep long-lived clones fast.
+	GC bool
+	// ReportAhead only looks for work that has not been pushed, recorded in
+	// RepoResult.Unpushed and Uncommitted, without fetching or pulling.
+	ReportAhead bool
+	// ReportFsck only checks the repository for corruption with git fsck,
+	// recorded in RepoResult.Corrupt and FsckErrors, without fetching or
+	// pulling.
+	ReportFsck bool
+	// Timeout limits how long a single git command may run. Zero means no
+	// limit.
+	Timeout time.Duration
+	// NameFromRemote names the repository after the owner and repository in
+	// its remote URL, recorded in RepoResult.Name and shown in its header.
+	NameFromRemote bool
+}
+
+type timeoutKey struct{}
+
+// withCommandTimeout returns a copy of ctx under which every git command is
+// limited to d.
+func withCommandTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+type gitConfigKey struct{}
+
+// WithGitConfig returns a copy of ctx under which every git command is run
+// with the given key=value settings, as if passed with -c.
+func WithGitConfig(ctx context.Context, config []string) context.Context {
+	return context.WithValue(ctx, gitConfigKey{}, config)
+}
+
+type remoteKey struct{}
+
+// DefaultRemote is the remote repositories are updated from unless
+// WithRemote names another.
+const DefaultRemote = "origin"
+
+// WithRemote returns a copy of ctx under which repositories are updated from
+// the remote called name instead of DefaultRemote.
+func WithRemote(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, remoteKey{}, name)
+}
+
+// remoteName returns the remote set with WithRemote, or DefaultRemote.
+func remoteName(ctx context.Context) string {
+	if name, _ := ctx.Value(remoteKey{}).(string); name != "" {
+		return name
+	}
+	return DefaultRemote
+}
+
+// ValidateGitConfig checks that entry has the key=value form expected by
+// git -c, with a key such as http.proxy.
+func ValidateGitConfig(entry string) error {
+	key, _, ok := strings.Cut(entry, "=")
+	if !ok {
+		return fmt.Errorf("git config %q is not in key=value form", entry)
+	}
+	
+	dot := strings.Index(key, ".")
+	if dot <= 0 || strings.HasSuffix(key, ".") || strings.ContainsAny(key, " \t\n") {
+		return fmt.Errorf("git config %q has an invalid key, expected e.g. http.proxy=...", entry)
+	}
+	
+	return nil
+}
 
 type RepoResult struct {
-	Path         string
-	Branch       string
-	Success      bool
-	ErrorMessage string
+	Path    string `json:"path"`
+	Branch  string `json:"branch,omitempty"`
+	Success bool   `json:"success"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Fetched bool   `json:"fetched,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	// Name is the owner/repo of the remote when NameFromRemote is set and
+	// the remote URL names one.
+	Name string `json:"name,omitempty"`
+	// Filtered is set for repositories left out because their origin did
+	// not match the remote filter.
+	Filtered bool `json:"filtered,omitempty"`
+	// Stale is set for skipped repositories without recent activity.
+	Stale bool `json:"stale,omitempty"`
+	// Cancelled is set for repositories that were not finished because the
+	// run was interrupted.
+	Cancelled bool `json:"cancelled,omitempty"`
+	// Ahead and Behind count the commits that differ between the branch and
+	// its counterpart on origin.
+	Ahead  int `json:"ahead,omitempty"`
+	Behind int `json:"behind,omitempty"`
+	// Branches holds the outcome for the other tracking branches when
+	// AllBranches is set. Branches that were already up to date are left out.
+	Branches []BranchResult `json:"branches,omitempty"`
+	// NewTags is the number of tags that were added by the update and
+	// MovedTags lists the tags that now point somewhere else.
+	NewTags   int      `json:"new_tags,omitempty"`
+	MovedTags []string `json:"moved_tags,omitempty"`
+	// Strategy describes how the repository was updated, such as
+	// "default-branch+ff-only" or "fetch-only", after overrides were applied.
+	Strategy string `json:"strategy,omitempty"`
+	// Changed is set when the pull brought in new commits.
+	Changed bool `json:"changed,omitempty"`
+	// HookError describes a hook failure that was ignored.
+	HookError string `json:"hook_error,omitempty"`
+	// Pruned is the number of stale remote-tracking branches removed.
+	Pruned int `json:"pruned,omitempty"`
+	// Reset is set when the branch could not be fast-forwarded and was
+	// reset to the remote instead, discarding Discarded local commits.
+	Reset     bool `json:"reset,omitempty"`
+	Discarded int  `json:"discarded,omitempty"`
+	// Unpushed lists the branches with commits that are not on their
+	// upstream, or on any remote for branches without one, and Uncommitted
+	// is set for changes that were never committed, when ReportAhead is set.
+	Unpushed    []UnpushedBranch `json:"unpushed,omitempty"`
+	Uncommitted bool             `json:"uncommitted,omitempty"`
+	// Corrupt is set when git fsck found problems, the first of which are
+	// listed in FsckErrors, when ReportFsck is set.
+	Corrupt    bool     `json:"corrupt,omitempty"`
+	FsckErrors []string `json:"fsck_errors,omitempty"`
+	// ChangedFiles lists the files the update left modified in a working
+	// tree that was clean, when VerifyClean is set.
+	ChangedFiles []string `json:"changed_files,omitempty"`
+	// ConflictFiles lists the tracked files that contain merge conflict
+	// markers after the update, when CheckConflicts is set.
+	ConflictFiles []string `json:"conflict_files,omitempty"`
+	// ObjectsReceived and BytesReceived count what was transferred from the
+	// remote when Stats is set.
+	ObjectsReceived int   `json:"objects_received,omitempty"`
+	BytesReceived   int64 `json:"bytes_received,omitempty"`
+	// Shallow is set for shallow clones, whose history is cut off.
+	// Unshallowed is set when their full history was fetched, which took
+	// UnshallowDuration.
+	Shallow           bool          `json:"shallow,omitempty"`
+	Unshallowed       bool          `json:"unshallowed,omitempty"`
+	UnshallowDuration time.Duration `json:"-"`
+	// GC is set when maintenance ran after the update, which took
+	// GCDuration.
+	GC         bool          `json:"gc,omitempty"`
+	GCDuration time.Duration `json:"-"`
+	// OpenRequests is the number of open pull or merge requests on the
+	// forge hosting origin, when it was looked up.
+	OpenRequests *int   `json:"open_requests,omitempty"`
+	ErrorMessage string `json:"error_message,omitempty"`
+	// Reason is the category of Err, for telling failures apart without
+	// parsing ErrorMessage.
+	Reason Reason `json:"reason,omitempty"`
+	// Err is why the repository failed or was skipped. It wraps one of the
+	// errors declared in this package where one applies.
+	Err error `json:"-"`
+	// Duration is the total time spent on the repository.
+	Duration time.Duration `json:"-"`
+}
+
+// BranchResult is the outcome of fast-forwarding a single branch.
+type BranchResult struct {
+	Name         string `json:"name"`
+	Success      bool   `json:"success"`
+	ErrorMessage string `json:"error_message,omitempty"`
+}
+
+// UnpushedBranch is a local branch with commits that would be lost along
+// with the repository.
+type UnpushedBranch struct {
+	Name string `json:"name"`
+	// Upstream is empty for branches that do not track a remote branch.
+	Upstream string `json:"upstream,omitempty"`
+	Commits  int    `json:"commits"`
+}
+
+// MarshalJSON encodes the durations as whole milliseconds.
+func (r RepoResult) MarshalJSON() ([]byte, error) {
+	type plain RepoResult
+	return json.Marshal(struct {
+		plain
+		UnshallowMS int64 `json:"unshallow_ms,omitempty"`
+		GCMS        int64 `json:"gc_ms,omitempty"`
+		DurationMS  int64 `json:"duration_ms"`
+	}{plain(r), r.UnshallowDuration.Milliseconds(), r.GCDuration.Milliseconds(), r.Duration.Milliseconds()})
 }
 
-func runGitCommand(dir string, args ...string) (string, error) {
-	cmd := ExecCommand("git", args...)
+// runGitCommand runs git in dir with the settings from WithGitConfig. Its
+// debug output goes to the logger.Buffer carried by ctx, if any. The command
+// is killed, along with any processes it started, when ctx is cancelled or
+// the command timeout carried by ctx expires.
+func runGitCommand(ctx context.Context, dir string, args ...string) (string, error) {
+	timeout, _ := ctx.Value(timeoutKey{}).(time.Duration)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	
+	config, _ := ctx.Value(gitConfigKey{}).([]string)
+	for i := len(config) - 1; i >= 0; i-- {
+		args = append([]string{"-c", config[i]}, args...)
+	}
+	
+	cmd := ExecCommand(ctx, "git", args...)
 	cmd.Dir = dir
+	// Fail instead of waiting for a username or password that nobody can
+	// type while several repositories are processed at once. Messages are
+	// kept untranslated since failures are classified, and transfers
+	// counted, by matching git's English output.
+	cmd.Env = append(commandEnv(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	killProcessGroup(cmd)
 	
-	logger.Debug("Running git %s in %s", strings.Join(args, " "), dir)
+	logger.FromContext(ctx).Debug("Running git %s in %s", strings.Join(args, " "), dir)
 	
-	output, err := cmd.CombinedOutput()
-	outputStr := strings.TrimSpace(string(output))
+	// Transfers are shown as they happen in debug output; the output is
+	// still collected for the error message and the transfer counts.
+	var output bytes.Buffer
+	var w io.Writer = &output
+	if logger.Enabled(logger.LevelDebug) && slices.Contains(args, "--progress") {
+		w = io.MultiWriter(&output, logger.LiveWriter(filepath.Base(dir)))
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := cmd.Run()
+	outputStr := strings.TrimSpace(output.String())
 	
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return outputStr, fmt.Errorf("git %s: %w after %v", subcommand(args), ErrTimeout, timeout)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return outputStr, fmt.Errorf("git %s: %w", subcommand(args), context.Canceled)
+	}
 	if err != nil {
+		// Keep the exit status unless the output says what went wrong.
+		if known := classifyOutput(outputStr); known != nil {
+			return outputStr, fmt.Errorf("git command failed: %w: %s", known, outputStr)
+		}
 		return outputStr, fmt.Errorf("git command failed: %v: %s", err, outputStr)
 	}
 	
 	return outputStr, nil
 }
 
-func IsGitRepo(dir string) bool {
-	_, err := runGitCommand(dir, "rev-parse", "--is-inside-work-tree")
-	return err == nil
+// repositoryEnv names the variables that point git at a repository other
+// than the one found from its working directory. They are set when pullio
+// runs from a git hook, and would make every command act on that one
+// repository.
+var repositoryEnv = []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_COMMON_DIR", "GIT_INDEX_FILE", "GIT_OBJECT_DIRECTORY"}
+
+// commandEnv returns the environment for git commands, without
+// repositoryEnv.
+func commandEnv() []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !slices.Contains(repositoryEnv, name) {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// subcommand returns the git command in args, skipping -c options.
+func subcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+			continue
+		}
+		return args[i]
+	}
+	
+	return ""
 }
 
-func HasOriginRemote(dir string) bool {
-	_, err := runGitCommand(dir, "remote", "get-url", "origin")
+// IsGitRepo reports whether dir is in the working tree of a repository,
+// whether its .git is a directory or a file pointing at one elsewhere.
+func IsGitRepo(ctx context.Context, dir string) bool {
+	output, err := runGitCommand(ctx, dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && output == "true"
+}
+
+func HasRemote(ctx context.Context, dir string) bool {
+	_, err := GetRemoteURL(ctx, dir)
 	return err == nil
 }
 
-func DetectDefaultBranch(dir string, fallbacks []string) (string, error) {
-	// Method 1: Check symbolic ref for origin/HEAD
-	output, err := runGitCommand(dir, "symbolic-ref", "--quiet", "refs/remotes/origin/HEAD")
+// GetRemoteURL returns the URL of the remote set with WithRemote.
+func GetRemoteURL(ctx context.Context, dir string) (string, error) {
+	output, err := runGitCommand(ctx, dir, "remote", "get-url", remoteName(ctx))
+	if err != nil && strings.Contains(output, "No such remote") {
+		return "", ErrNoRemote
+	}
+	return output, err
+}
+
+// LastActivity returns the later of the time of the HEAD commit and the time
+// HEAD was last moved, for example by a checkout.
+func LastActivity(ctx context.Context, dir string) (time.Time, error) {
+	output, err := runGitCommand(ctx, dir, "log", "-1", "--format=%ct")
+	if err != nil {
+		return time.Time{}, err
+	}
+	seconds, err := strconv.ParseInt(output, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unexpected commit time %q", output)
+	}
+	last := time.Unix(seconds, 0)
+	
+	headPath, err := runGitCommand(ctx, dir, "rev-parse", "--git-path", "HEAD")
+	if err == nil {
+		if !filepath.IsAbs(headPath) {
+			headPath = filepath.Join(dir, headPath)
+		}
+		if info, err := os.Stat(headPath); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	
+	return last, nil
+}
+
+// CommonDir returns the git directory shared by all worktrees of the
+// repository at dir.
+func CommonDir(ctx context.Context, dir string) (string, error) {
+	commonDir, err := runGitCommand(ctx, dir, "rev-parse", "--git-common-dir")
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(dir, commonDir)
+	}
+	
+	return filepath.Clean(commonDir), nil
+}
+
+// repoName returns the owner/repo of the remote of the repository at
+// repoPath, or an empty string when it has none or its URL cannot be parsed.
+func repoName(ctx context.Context, g Git, repoPath string) string {
+	remoteURL, err := g.RemoteURL(ctx, repoPath)
+	if err != nil {
+		return ""
+	}
+	
+	_, owner, repo, err := ParseRemoteURL(remoteURL)
+	if err != nil {
+		logger.Debug("Showing the path of %s: %v", repoPath, err)
+		return ""
+	}
+	return owner + "/" + repo
+}
+
+// GitDirs returns the git directory of the worktree at dir and the one it
+// shares with the other worktrees of its repository, with symbolic links
+// resolved so every path that leads to them gives the same result.
+func GitDirs(dir string) (gitDir, commonDir string, err error) {
+	gitDir, commonDir, err = gitDirs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if gitDir, err = filepath.EvalSymlinks(gitDir); err != nil {
+		return "", "", err
+	}
+	if commonDir, err = filepath.EvalSymlinks(commonDir); err != nil {
+		return "", "", err
+	}
+	return gitDir, commonDir, nil
+}
+
+// isLinkedWorktree reports whether dir is a worktree added with git
+// worktree add rather than the main worktree of its repository.
+func isLinkedWorktree(dir string) bool {
+	gitDir, commonDir, err := gitDirs(dir)
+	return err == nil && gitDir != commonDir
+}
+
+// repoLocks maps the common git directory of each repository to the mutex
+// that serializes work on its worktrees.
+var repoLocks sync.Map
+
+// lockRepository waits until no other worktree of the repository at
+// repoPath is being processed and returns its common git directory and the
+// function that releases it.
+func lockRepository(ctx context.Context, g Git, repoPath string) (string, func()) {
+	commonDir, err := g.CommonDir(ctx, repoPath)
+	if err != nil {
+		return "", func() {}
+	}
+	
+	mu, _ := repoLocks.LoadOrStore(commonDir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return commonDir, mu.(*sync.Mutex).Unlock
+}
+
+type fetchedKey struct{}
+
+// WithSharedFetch makes the worktrees of one repository processed with ctx
+// fetch once: the first one fetches and the others update their branch
+// from what it fetched, since worktrees share the refs of their repository.
+func WithSharedFetch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fetchedKey{}, &sync.Map{})
+}
+
+// alreadyFetched reports whether a worktree of the repository whose common
+// git directory is commonDir was fetched with ctx before.
+func alreadyFetched(ctx context.Context, commonDir string) bool {
+	fetched, _ := ctx.Value(fetchedKey{}).(*sync.Map)
+	if fetched == nil || commonDir == "" {
+		return false
+	}
+	
+	_, ok := fetched.Load(commonDir)
+	return ok
+}
+
+// markFetched records that the repository whose common git directory is
+// commonDir was fetched with ctx.
+func markFetched(ctx context.Context, commonDir string) {
+	if fetched, _ := ctx.Value(fetchedKey{}).(*sync.Map); fetched != nil && commonDir != "" {
+		fetched.Store(commonDir, true)
+	}
+}
+
+// IsSSHURL reports whether a remote URL is reached over SSH, either as an
+// ssh:// URL or in the scp-like user@host:path form.
+func IsSSHURL(url string) bool {
+	if strings.HasPrefix(url, "ssh://") || strings.HasPrefix(url, "git+ssh://") || strings.HasPrefix(url, "ssh+git://") {
+		return true
+	}
+	if strings.Contains(url, "://") {
+		return false
+	}
+	
+	// A colon before any slash means scp-like syntax; a single letter before
+	// it is a Windows drive instead.
+	colon := strings.Index(url, ":")
+	return colon > 1 && !strings.Contains(url[:colon], "/")
+}
+
+// RemoteHost returns the lower-cased host name of a remote URL, or an empty
+// string for local paths.
+func RemoteHost(remoteURL string) string {
+	host, _, err := splitRemoteURL(remoteURL)
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// ParseRemoteURL splits a remote URL such as git@github.com:owner/repo.git,
+// ssh://git@host:port/owner/repo.git or https://host/owner/repo.git into
+// the lower-cased host, the owner and the repository name without .git. The
+// owner holds all but the last path element, e.g. the group and subgroups on
+// GitLab. Local paths and URLs without an owner are an error.
+func ParseRemoteURL(remoteURL string) (host, owner, repo string, err error) {
+	host, path, err := splitRemoteURL(remoteURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	slash := strings.LastIndex(path, "/")
+	if host == "" || slash <= 0 || slash == len(path)-1 {
+		return "", "", "", fmt.Errorf("cannot find an owner and repository in remote %q", remoteURL)
+	}
+	
+	return host, path[:slash], path[slash+1:], nil
+}
+
+// splitRemoteURL returns the lower-cased host and the path of a remote URL
+// in URL or scp-like form.
+func splitRemoteURL(remoteURL string) (host, path string, err error) {
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid remote URL %q: %w", remoteURL, err)
+		}
+		return strings.ToLower(u.Hostname()), u.Path, nil
+	}
+	if !IsSSHURL(remoteURL) {
+		return "", "", fmt.Errorf("remote %q is a local path", remoteURL)
+	}
+	
+	// scp-like syntax: [user@]host:path
+	host, path, _ = strings.Cut(remoteURL, ":")
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return strings.ToLower(host), path, nil
+}
+
+// DetectDefaultBranch asks the remote for its default branch and falls back to
+// the first of fallbacks that exists locally, then to the first local branch
+// in name order that matches pattern, when it is not nil.
+func DetectDefaultBranch(ctx context.Context, dir string, fallbacks []string, pattern *regexp.Regexp) (string, error) {
+	remote := remoteName(ctx)
+	
+	// Method 1: Check symbolic ref for <remote>/HEAD
+	output, err := runGitCommand(ctx, dir, "symbolic-ref", "--quiet", "refs/remotes/"+remote+"/HEAD")
 	if err == nil {
-		branch := strings.TrimPrefix(output, "refs/remotes/origin/")
-		logger.Debug("Found default branch via symbolic-ref: %s", branch)
+		branch := strings.TrimPrefix(output, "refs/remotes/"+remote+"/")
+		logger.FromContext(ctx).Debug("Found default branch via symbolic-ref: %s", branch)
 		return branch, nil
 	}
 	
-	// Method 2: Use git remote show origin
-	output, err = runGitCommand(dir, "remote", "show", "origin")
+	// Method 2: Use git remote show <remote>
+	output, err = runGitCommand(ctx, dir, "remote", "show", remote)
 	if err == nil {
 		for _, line := range strings.Split(output, "\n") {
 			if strings.Contains(line, "HEAD branch:") {
 				parts := strings.Fields(line)
 				if len(parts) > 0 {
 					branch := parts[len(parts)-1]
-					logger.Debug("Found default branch via remote show: %s", branch)
+					logger.FromContext(ctx).Debug("Found default branch via remote show: %s", branch)
 					return branch, nil
 				}
 			}
@@ -71,76 +617,1390 @@ func DetectDefaultBranch(dir string, fallbacks []string) (string, error) {
 	
 	// Method 3: Check for common branch names
 	for _, branch := range fallbacks {
-		_, err := runGitCommand(dir, "show-ref", "--quiet", "refs/heads/"+branch)
+		_, err := runGitCommand(ctx, dir, "show-ref", "--quiet", "refs/heads/"+branch)
 		if err == nil {
-			logger.Debug("Found default branch via fallback: %s", branch)
+			logger.FromContext(ctx).Debug("Found default branch via fallback: %s", branch)
 			return branch, nil
 		}
 	}
 	
+	// Method 4: Match local branch names against the pattern
+	if pattern != nil {
+		output, err := runGitCommand(ctx, dir, "for-each-ref", "--format=%(refname:short)", "refs/heads")
+		if err == nil {
+			if branch, ok := matchBranch(strings.Fields(output), pattern); ok {
+				logger.FromContext(ctx).Debug("Found default branch via pattern: %s", branch)
+				return branch, nil
+			}
+		}
+	}
+	
 	return "", fmt.Errorf("could not detect default branch")
 }
 
-func CheckoutBranch(dir, branch string) error {
-	_, err := runGitCommand(dir, "checkout", "-q", branch)
+// RefreshDefaultBranch asks the remote which branch its HEAD points at,
+// updates the remote's HEAD that DetectDefaultBranch reads first and returns
+// the branch.
+func RefreshDefaultBranch(ctx context.Context, dir string, opts Options) (string, error) {
+	remote := remoteName(ctx)
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, "remote", "set-head", remote, "--auto")...)
+	if err != nil && isCredentialError(output) {
+		return "", ErrNoCredentials
+	}
+	if err != nil {
+		return "", err
+	}
+	
+	output, err = runGitCommand(ctx, dir, "symbolic-ref", "--quiet", "refs/remotes/"+remote+"/HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(output, "refs/remotes/"+remote+"/"), nil
+}
+
+// defaultBranch detects the default branch of the repository, or returns
+// opts.FallbackBranch when it cannot be detected. Whether the fallback exists
+// is left to checking it out.
+func defaultBranch(ctx context.Context, g Git, repoPath string, opts Options) (string, error) {
+	branch, err := g.DefaultBranch(ctx, repoPath, opts.DefaultBranches, opts.DefaultBranchPattern)
+	if err == nil || opts.FallbackBranch == "" {
+		return branch, err
+	}
+	
+	logger.FromContext(ctx).Warning("Could not detect the default branch, trying %s", opts.FallbackBranch)
+	return opts.FallbackBranch, nil
+}
+
+// matchBranch returns the first of branches, in name order, that matches
+// pattern.
+func matchBranch(branches []string, pattern *regexp.Regexp) (string, bool) {
+	sorted := append([]string(nil), branches...)
+	sort.Strings(sorted)
+	for _, branch := range sorted {
+		if pattern.MatchString(branch) {
+			return branch, true
+		}
+	}
+	
+	return "", false
+}
+
+// ResetHard points the checked out branch at ref and makes the working tree
+// match it, discarding commits and changes that are not on ref.
+func ResetHard(ctx context.Context, dir, ref string) error {
+	_, err := runGitCommand(ctx, dir, "reset", "-q", "--hard", ref)
+	return err
+}
+
+// CurrentBranch returns the name of the checked out branch. It fails when
+// HEAD is detached.
+func CurrentBranch(ctx context.Context, dir string) (string, error) {
+	return runGitCommand(ctx, dir, "symbolic-ref", "--short", "-q", "HEAD")
+}
+
+// UpstreamExists reports whether branch has an upstream branch configured.
+func UpstreamExists(ctx context.Context, dir, branch string) bool {
+	_, err := runGitCommand(ctx, dir, "rev-parse", "--abbrev-ref", "--symbolic-full-name", branch+"@{upstream}")
+	return err == nil
+}
+
+// IsDetachedHead reports whether HEAD points at a commit rather than a
+// branch, as it does during a bisect or after checking out a tag.
+func IsDetachedHead(ctx context.Context, dir string) bool {
+	_, err := runGitCommand(ctx, dir, "symbolic-ref", "-q", "HEAD")
+	return err != nil
+}
+
+// IsWorkingTreeClean reports whether the repository has no uncommitted
+// changes to tracked files. Untracked files are ignored since they do not
+// get in the way of a checkout or pull.
+func IsWorkingTreeClean(ctx context.Context, dir string) bool {
+	output, err := runGitCommand(ctx, dir, "status", "--porcelain", "--untracked-files=no")
+	return err == nil && output == ""
+}
+
+// ModifiedFiles lists the tracked files that differ from HEAD.
+func ModifiedFiles(ctx context.Context, dir string) ([]string, error) {
+	output, err := runGitCommand(ctx, dir, "diff", "--name-only", "HEAD")
+	if err != nil || output == "" {
+		return nil, err
+	}
+	
+	return strings.Split(output, "\n"), nil
+}
+
+func StashPush(ctx context.Context, dir string) error {
+	_, err := runGitCommand(ctx, dir, "stash", "push", "-q", "-m", "pullio autostash")
 	return err
 }
 
-func Pull(dir string) error {
-	_, err := runGitCommand(dir, "pull", "-q")
+func StashPop(ctx context.Context, dir string) error {
+	_, err := runGitCommand(ctx, dir, "stash", "pop", "-q")
 	return err
 }
 
-func ProcessRepository(repoPath string, defaultBranches []string) RepoResult {
-	logger.RepoHeader(repoPath)
+func CheckoutBranch(ctx context.Context, dir, branch string) error {
+	// A branch that only exists on a remote is created from the configured
+	// remote even when others have a branch of the same name.
+	_, err := runGitCommand(ctx, dir, "-c", "checkout.defaultRemote="+remoteName(ctx), "checkout", "-q", branch)
+	return err
+}
+
+// remoteArgs prefixes a command that talks to the remote with the
+// configuration it needs.
+func remoteArgs(opts Options, args ...string) []string {
+	// core.sshCommand is run by the shell, so the path is quoted.
+	if opts.SSHKey != "" {
+		sshCommand := "ssh -i '" + strings.ReplaceAll(opts.SSHKey, "'", `'\''`) + "' -o IdentitiesOnly=yes"
+		args = append([]string{"-c", "core.sshCommand=" + sshCommand}, args...)
+	}
+	if opts.CredentialHelper != "" {
+		args = append([]string{"-c", "credential.helper=" + opts.CredentialHelper}, args...)
+	}
 	
-	result := RepoResult{
-		Path:    repoPath,
-		Success: false,
+	return args
+}
+
+// HasSSHCommand reports whether the repository picks its own SSH command,
+// and with it usually its own key, with core.sshCommand.
+func HasSSHCommand(ctx context.Context, dir string) bool {
+	output, err := runGitCommand(ctx, dir, "config", "--local", "--get", "core.sshCommand")
+	return err == nil && output != ""
+}
+
+// isCredentialError reports whether git failed because it would have had to
+// prompt for HTTPS credentials.
+func isCredentialError(output string) bool {
+	return strings.Contains(output, "terminal prompts disabled") ||
+		strings.Contains(output, "could not read Username") ||
+		strings.Contains(output, "could not read Password")
+}
+
+// authFailureMessage explains an authentication failure in terms of what to
+// check for the kind of remote the repository uses.
+func authFailureMessage(ctx context.Context, g Git, dir string, err error) string {
+	if errors.Is(err, ErrNoCredentials) {
+		return "No credentials for HTTPS remote, configure a git credential helper"
 	}
 	
-	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-		result.ErrorMessage = "Directory does not exist"
-		logger.Error("Directory does not exist: %s", repoPath)
-		return result
+	url, _ := g.RemoteURL(ctx, dir)
+	if IsSSHURL(url) {
+		return "Authentication failed, is the right SSH key loaded?"
+	}
+	return "Authentication failed, check the credentials stored for this remote"
+}
+
+// tagArgs returns the arguments that make a fetch or pull from remote bring
+// in all tags, or none, as opts asks. Git refuses to update a tag that was
+// moved on the remote without --force. With neither, git follows the tags of
+// fetched commits unless the remote's tagOpt says otherwise, and no setting
+// given to git brings that back. Such a remote fetches no tags instead and
+// follow reports that followTags has to follow them afterwards.
+func tagArgs(ctx context.Context, dir, remote string, opts Options) (args []string, follow bool) {
+	switch {
+	case opts.Tags:
+		return []string{"--tags", "--force"}, false
+	case opts.NoTags:
+		return []string{"--no-tags"}, false
 	}
 	
-	if !IsGitRepo(repoPath) {
-		result.ErrorMessage = "Not a Git repository"
-		logger.Warning("Not a Git repository")
-		return result
+	tagOpt, _ := runGitCommand(ctx, dir, "config", "--get", "remote."+remote+".tagOpt")
+	if tagOpt == "" {
+		return nil, false
+	}
+	return []string{"--no-tags"}, true
+}
+
+// followBatch is the number of commits or tags given to a single git command
+// by followTags, which keeps the command line short enough for Windows.
+const followBatch = 200
+
+// followTags fetches the tags on remote that the repository does not have
+// but whose commits it does, as git does itself for remotes without a
+// tagOpt setting.
+func followTags(ctx context.Context, dir, remote string, opts Options) error {
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, "ls-remote", "--tags", remote)...)
+	if err != nil && isCredentialError(output) {
+		return ErrNoCredentials
+	}
+	if err != nil {
+		return err
+	}
+	local, err := ListTags(ctx, dir)
+	if err != nil {
+		return err
 	}
 	
-	if !HasOriginRemote(repoPath) {
-		result.ErrorMessage = "No origin remote"
-		logger.Warning("No origin remote")
-		return result
+	// Annotated tags are listed a second time, peeled to the commit they
+	// point at.
+	commits := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		hash, ref, _ := strings.Cut(line, "\t")
+		name, ok := strings.CutPrefix(ref, "refs/tags/")
+		if !ok {
+			continue
+		}
+		if tag, peeled := strings.CutSuffix(name, "^{}"); peeled {
+			commits[tag] = hash
+		} else if _, seen := commits[name]; !seen {
+			commits[name] = hash
+		}
 	}
 	
-	branch, err := DetectDefaultBranch(repoPath, defaultBranches)
+	tagsAt := make(map[string][]string)
+	for name, hash := range commits {
+		if _, ok := local[name]; !ok {
+			tagsAt[hash] = append(tagsAt[hash], name)
+		}
+	}
+	hashes := make([]string, 0, len(tagsAt))
+	for hash := range tagsAt {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	
+	// rev-list leaves out the commits the repository does not have.
+	var refspecs []string
+	for start := 0; start < len(hashes); start += followBatch {
+		batch := hashes[start:min(start+followBatch, len(hashes))]
+		output, err := runGitCommand(ctx, dir, append([]string{"rev-list", "--no-walk", "--ignore-missing"}, batch...)...)
+		if err != nil {
+			return err
+		}
+		for _, hash := range strings.Fields(output) {
+			for _, name := range tagsAt[hash] {
+				refspecs = append(refspecs, "refs/tags/"+name+":refs/tags/"+name)
+			}
+		}
+	}
+	sort.Strings(refspecs)
+	
+	for start := 0; start < len(refspecs); start += followBatch {
+		batch := refspecs[start:min(start+followBatch, len(refspecs))]
+		args := append([]string{"fetch", "--no-tags", remote}, batch...)
+		output, err := runGitCommand(ctx, dir, remoteArgs(opts, args...)...)
+		if err != nil && isCredentialError(output) {
+			return ErrNoCredentials
+		}
+		if err != nil {
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// pullRemote returns the remote Pull pulls from: the one set with WithRemote
+// or, for DefaultRemote, the remote of the checked out branch's upstream.
+func pullRemote(ctx context.Context, dir, branch string) string {
+	if remote := remoteName(ctx); remote != DefaultRemote {
+		return remote
+	}
+	if remote, err := runGitCommand(ctx, dir, "config", "--get", "branch."+branch+".remote"); err == nil && remote != "" {
+		return remote
+	}
+	return DefaultRemote
+}
+
+// Pull pulls the checked out branch from its upstream or, when WithRemote
+// names a remote other than DefaultRemote, from the branch of the same name
+// on that remote. What was received is only counted when opts.Stats is set.
+func Pull(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	args := append([]string{"pull"}, progressArgs(opts)...)
+	if opts.FFOnly {
+		args = append(args, "--ff-only")
+	}
+	branch, err := CurrentBranch(ctx, dir)
 	if err != nil {
-		result.ErrorMessage = fmt.Sprintf("Failed to detect default branch: %v", err)
-		logger.Error("Failed to detect default branch: %v", err)
-		return result
+		return Transfer{}, err
+	}
+	remote := pullRemote(ctx, dir, branch)
+	tags, follow := tagArgs(ctx, dir, remote, opts)
+	args = append(args, tags...)
+	if remote := remoteName(ctx); remote != DefaultRemote {
+		args = append(args, remote, branch)
 	}
-	result.Branch = branch
 	
-	startTime := time.Now()
-	if err := CheckoutBranch(repoPath, branch); err != nil {
-		result.ErrorMessage = fmt.Sprintf("Failed to checkout branch %s: %v", branch, err)
-		logger.Error("Failed to checkout branch %s: %v", branch, err)
-		return result
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, statsArgs(opts, args...)...)...)
+	if err != nil && opts.FFOnly && strings.Contains(output, "Not possible to fast-forward") {
+		return Transfer{}, ErrDiverged
+	}
+	if err != nil && isCredentialError(output) {
+		return Transfer{}, ErrNoCredentials
+	}
+	if err == nil && follow {
+		err = followTags(ctx, dir, remote, opts)
+	}
+	return parseTransfer(output), err
+}
+
+// MergeUpstream brings the checked out branch up to date with its upstream
+// as it was last fetched, like Pull without contacting the remote. The
+// branch is pulled from the repository itself, so pull.rebase and the other
+// pull settings apply as they do to Pull.
+func MergeUpstream(ctx context.Context, dir string, opts Options) error {
+	upstream := "@{upstream}"
+	if remote := remoteName(ctx); remote != DefaultRemote {
+		branch, err := CurrentBranch(ctx, dir)
+		if err != nil {
+			return err
+		}
+		upstream = remote + "/" + branch
+	}
+	ref, err := runGitCommand(ctx, dir, "rev-parse", "--symbolic-full-name", upstream)
+	if err != nil {
+		return err
 	}
-	logger.Debug("Checked out branch %s in %v", branch, time.Since(startTime))
 	
-	pullStart := time.Now()
-	if err := Pull(repoPath); err != nil {
-		result.ErrorMessage = fmt.Sprintf("Failed to pull: %v", err)
-		logger.Error("Failed to pull: %v", err)
-		return result
+	args := []string{"pull", "-q", "--no-tags"}
+	if opts.FFOnly {
+		args = append(args, "--ff-only")
+	}
+	output, err := runGitCommand(ctx, dir, append(args, ".", ref)...)
+	if err != nil && opts.FFOnly && strings.Contains(output, "Not possible to fast-forward") {
+		return ErrDiverged
+	}
+	return err
+}
+
+// UpdateSubmodules brings all submodules in line with the superproject. It
+// is a no-op for repositories without submodules.
+func UpdateSubmodules(ctx context.Context, dir string) error {
+	_, err := runGitCommand(ctx, dir, "submodule", "update", "-q", "--init", "--recursive")
+	return err
+}
+
+// AheadBehind counts the commits on branch that are not on the same branch
+// of the remote set with WithRemote, and the commits there that are not on
+// branch.
+func AheadBehind(ctx context.Context, dir, branch string) (ahead, behind int, err error) {
+	output, err := runGitCommand(ctx, dir, "rev-list", "--left-right", "--count", remoteName(ctx)+"/"+branch+"..."+branch)
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	fields := strings.Fields(output)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", output)
+	}
+	if behind, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", output)
+	}
+	if ahead, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", output)
+	}
+	
+	return ahead, behind, nil
+}
+
+// ListTags returns the local tags mapped to the object each one points at.
+func ListTags(ctx context.Context, dir string) (map[string]string, error) {
+	output, err := runGitCommand(ctx, dir, "for-each-ref", "--format=%(refname:short) %(objectname)", "refs/tags")
+	if err != nil {
+		return nil, err
+	}
+	
+	tags := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			tags[fields[0]] = fields[1]
+		}
+	}
+	
+	return tags, nil
+}
+
+// TrackingBranch is a local branch together with its upstream.
+type TrackingBranch struct {
+	Name string
+	// Upstream is the full name of the upstream ref, such as
+	// refs/remotes/origin/main.
+	Upstream string
+}
+
+// LocalBranches returns all local branches. Upstream is empty for the ones
+// without an upstream.
+func LocalBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	output, err := runGitCommand(ctx, dir, "for-each-ref", "--format=%(refname:short) %(upstream)", "refs/heads")
+	if err != nil {
+		return nil, err
+	}
+	
+	var branches []TrackingBranch
+	for _, line := range strings.Split(output, "\n") {
+		fields := append(strings.Fields(line), "")
+		if fields[0] != "" {
+			branches = append(branches, TrackingBranch{Name: fields[0], Upstream: fields[1]})
+		}
+	}
+	
+	return branches, nil
+}
+
+// TrackingBranches returns the local branches that have an upstream.
+func TrackingBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	branches, err := LocalBranches(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	
+	var tracking []TrackingBranch
+	for _, b := range branches {
+		if b.Upstream != "" {
+			tracking = append(tracking, b)
+		}
+	}
+	
+	return tracking, nil
+}
+
+// CountUnpushed counts the commits on b that are not on its upstream, or on
+// any remote-tracking branch when it has no upstream.
+func CountUnpushed(ctx context.Context, dir string, b TrackingBranch) (int, error) {
+	args := []string{"rev-list", "--count", "refs/heads/" + b.Name, "--not", "--remotes"}
+	if b.Upstream != "" {
+		args = []string{"rev-list", "--count", b.Upstream + "..refs/heads/" + b.Name}
+	}
+	
+	output, err := runGitCommand(ctx, dir, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := strconv.Atoi(output)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected rev-list output %q", output)
+	}
+	return count, nil
+}
+
+// FastForwardBranch moves a branch that is not checked out to its upstream.
+// It reports whether the branch moved and returns ErrDiverged when the branch
+// has commits that are not on the upstream.
+func FastForwardBranch(ctx context.Context, dir string, b TrackingBranch) (bool, error) {
+	local, err := runGitCommand(ctx, dir, "rev-parse", "refs/heads/"+b.Name)
+	if err != nil {
+		return false, err
+	}
+	upstream, err := runGitCommand(ctx, dir, "rev-parse", b.Upstream)
+	if err != nil {
+		return false, err
+	}
+	if local == upstream {
+		return false, nil
+	}
+	
+	if _, err := runGitCommand(ctx, dir, "merge-base", "--is-ancestor", local, upstream); err != nil {
+		// A branch that is only ahead of its upstream has nothing to pull.
+		if _, err := runGitCommand(ctx, dir, "merge-base", "--is-ancestor", upstream, local); err == nil {
+			return false, nil
+		}
+		return false, ErrDiverged
+	}
+	
+	_, err = runGitCommand(ctx, dir, "update-ref", "-m", "pullio: fast-forward", "refs/heads/"+b.Name, upstream, local)
+	return err == nil, err
+}
+
+// PruneRemote deletes remote-tracking branches of the remote set with
+// WithRemote that no longer exist there and returns how many were removed.
+func PruneRemote(ctx context.Context, dir string, opts Options) (int, error) {
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, "remote", "prune", remoteName(ctx))...)
+	if err != nil && isCredentialError(output) {
+		return 0, ErrNoCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	
+	return strings.Count(output, "[pruned]"), nil
+}
+
+// Maintenance runs git maintenance, falling back to git gc for git versions
+// that do not have it.
+func Maintenance(ctx context.Context, dir string) error {
+	output, err := runGitCommand(ctx, dir, "maintenance", "run", "--quiet")
+	if err != nil && strings.Contains(output, "is not a git command") {
+		_, err = runGitCommand(ctx, dir, "gc", "--quiet")
+	}
+	return err
+}
+
+// Fetch fetches the remote set with WithRemote. What was received is only
+// counted when opts.Stats is set.
+func Fetch(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	remote := remoteName(ctx)
+	tags, follow := tagArgs(ctx, dir, remote, opts)
+	args := append([]string{"fetch"}, progressArgs(opts)...)
+	args = append(append(args, "--prune"), tags...)
+	args = append(args, remote)
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, statsArgs(opts, args...)...)...)
+	if err != nil && isCredentialError(output) {
+		return Transfer{}, ErrNoCredentials
+	}
+	if err == nil && follow {
+		err = followTags(ctx, dir, remote, opts)
+	}
+	return parseTransfer(output), err
+}
+
+// inProgressMarkers maps the files git keeps in the git directory while an
+// operation is unfinished to the name of the operation.
+var inProgressMarkers = []struct {
+	file      string
+	operation string
+}{
+	{"rebase-merge", "rebase"},
+	{"rebase-apply/applying", "am"},
+	{"rebase-apply", "rebase"},
+	{"MERGE_HEAD", "merge"},
+	{"CHERRY_PICK_HEAD", "cherry-pick"},
+	{"REVERT_HEAD", "revert"},
+	{"BISECT_LOG", "bisect"},
+}
+
+// InProgressOperation returns the name of the operation, such as "rebase" or
+// "merge", that was started in the repository and not finished yet.
+func InProgressOperation(ctx context.Context, dir string) (string, bool) {
+	gitDir, err := runGitCommand(ctx, dir, "rev-parse", "--git-dir")
+	if err != nil {
+		return "", false
+	}
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(dir, gitDir)
+	}
+	
+	return operationInProgress(gitDir)
+}
+
+// operationInProgress looks for the markers of an unfinished operation in
+// gitDir.
+func operationInProgress(gitDir string) (string, bool) {
+	for _, m := range inProgressMarkers {
+		if _, err := os.Stat(filepath.Join(gitDir, m.file)); err == nil {
+			return m.operation, true
+		}
+	}
+	
+	return "", false
+}
+
+// IsShallow reports whether the repository is a shallow clone.
+func IsShallow(ctx context.Context, dir string) bool {
+	output, err := runGitCommand(ctx, dir, "rev-parse", "--is-shallow-repository")
+	return err == nil && output == "true"
+}
+
+// Unshallow fetches the history missing from a shallow clone.
+func Unshallow(ctx context.Context, dir string, opts Options) error {
+	remote := remoteName(ctx)
+	tags, follow := tagArgs(ctx, dir, remote, opts)
+	args := append([]string{"fetch", "--unshallow"}, progressArgs(opts)...)
+	args = append(args, tags...)
+	output, err := runGitCommand(ctx, dir, remoteArgs(opts, append(args, remote)...)...)
+	if err != nil && isCredentialError(output) {
+		return ErrNoCredentials
+	}
+	if err == nil && follow {
+		err = followTags(ctx, dir, remote, opts)
+	}
+	return err
+}
+
+// unshallow fetches the full history of a shallow clone when opts asks for
+// it. It records a failure in result and returns false when the update
+// should stop.
+func unshallow(ctx context.Context, g Git, repoPath string, opts Options, result *RepoResult) bool {
+	if !result.Shallow || !opts.Unshallow {
+		return true
+	}
+	log := logger.FromContext(ctx)
+	
+	// Fetching the whole history of a big repository can take a while, so
+	// it is timed apart from the update itself.
+	start := time.Now()
+	if err := g.Unshallow(ctx, repoPath, opts); err != nil {
+		result.Err = err
+		result.ErrorMessage = fmt.Sprintf("Failed to unshallow: %v", err)
+		log.Error("Failed to unshallow: %v", err)
+		return false
+	}
+	result.UnshallowDuration = time.Since(start)
+	result.Unshallowed = true
+	log.Info("Fetched the full history in %v", result.UnshallowDuration)
+	return true
+}
+
+// collectGarbage runs maintenance after a successful pull. It is timed apart
+// from the update and, like pruning, a failure is only reported.
+func collectGarbage(ctx context.Context, g Git, repoPath string, result *RepoResult) {
+	log := logger.FromContext(ctx)
+	
+	start := time.Now()
+	if err := g.Maintenance(ctx, repoPath); err != nil {
+		log.Warning("Failed to run maintenance: %v", err)
+		return
+	}
+	result.GCDuration = time.Since(start)
+	result.GC = true
+	log.Info("Ran maintenance in %v", result.GCDuration)
+}
+
+// listTagsBefore lists the tags before an update when tags are fetched. It
+// returns nil when they are not or the tags cannot be listed.
+func listTagsBefore(ctx context.Context, g Git, repoPath string, opts Options) map[string]string {
+	if !opts.Tags {
+		return nil
+	}
+	
+	tags, err := g.ListTags(ctx, repoPath)
+	if err != nil {
+		logger.FromContext(ctx).Debug("Failed to list tags: %v", err)
+		return nil
+	}
+	return tags
+}
+
+// recordTagChanges compares the tags from before an update with the current
+// ones and records which were added or moved.
+func recordTagChanges(ctx context.Context, g Git, repoPath string, before map[string]string, result *RepoResult) {
+	log := logger.FromContext(ctx)
+	
+	after, err := g.ListTags(ctx, repoPath)
+	if err != nil {
+		log.Debug("Failed to list tags: %v", err)
+		return
+	}
+	
+	for name, object := range after {
+		old, ok := before[name]
+		if !ok {
+			result.NewTags++
+		} else if old != object {
+			result.MovedTags = append(result.MovedTags, name)
+		}
+	}
+	sort.Strings(result.MovedTags)
+	
+	if result.NewTags > 0 {
+		log.Info("Fetched %d new tags", result.NewTags)
+	}
+	if len(result.MovedTags) > 0 {
+		log.Warning("Tags moved on the remote: %s", strings.Join(result.MovedTags, ", "))
+	}
+}
+
+// countAheadBehind records how far result.Branch is ahead of and behind
+// origin. Failing to count is not an error for the repository.
+func countAheadBehind(ctx context.Context, g Git, repoPath string, result *RepoResult) {
+	ahead, behind, err := g.AheadBehind(ctx, repoPath, result.Branch)
+	if err != nil {
+		logger.FromContext(ctx).Debug("Failed to count commits ahead of and behind %s/%s: %v", remoteName(ctx), result.Branch, err)
+		return
+	}
+	
+	result.Ahead = ahead
+	result.Behind = behind
+}
+
+// countSkippedBehind records how far the default branch of a repository that
+// is skipped, or result.Branch once it is known, is ahead of and behind the
+// remote, so the summary shows what the repository is missing. It fetches
+// first, which leaves the working tree alone, except in a dry run, where the
+// refs from the last fetch are used. Failures only show in debug output.
+func countSkippedBehind(ctx context.Context, g Git, repoPath string, opts Options, result *RepoResult) {
+	log := logger.FromContext(ctx)
+	
+	if result.Branch == "" {
+		branch, err := defaultBranch(ctx, g, repoPath, opts)
+		if err != nil {
+			log.Debug("Failed to detect the default branch of a skipped repository: %v", err)
+			return
+		}
+		result.Branch = branch
+	}
+	
+	if !opts.DryRun {
+		if _, err := g.Fetch(ctx, repoPath, opts); err != nil {
+			log.Debug("Failed to fetch a skipped repository: %v", err)
+		}
+	}
+	countAheadBehind(ctx, g, repoPath, result)
+	if result.Behind > 0 {
+		log.Info("%s is %d commits behind %s", result.Branch, result.Behind, remoteName(ctx))
+	}
+}
+
+// fastForwardOtherBranches fast-forwards every branch with an upstream except
+// current, which has just been pulled, using the remote-tracking refs the
+// pull fetched.
+func fastForwardOtherBranches(ctx context.Context, g Git, repoPath, current string) []BranchResult {
+	log := logger.FromContext(ctx)
+	
+	branches, err := g.TrackingBranches(ctx, repoPath)
+	if err != nil {
+		log.Warning("Failed to list tracking branches: %v", err)
+		return nil
+	}
+	
+	var results []BranchResult
+	for _, b := range branches {
+		if b.Name == current {
+			continue
+		}
+		
+		moved, err := g.FastForwardBranch(ctx, repoPath, b)
+		if errors.Is(err, ErrDiverged) {
+			results = append(results, BranchResult{Name: b.Name, ErrorMessage: "Cannot fast-forward, diverged from upstream"})
+			log.Warning("Cannot fast-forward %s, it has diverged from its upstream", b.Name)
+			continue
+		}
+		if err != nil {
+			results = append(results, BranchResult{Name: b.Name, ErrorMessage: fmt.Sprintf("Failed to fast-forward: %v", err)})
+			log.Warning("Failed to fast-forward %s: %v", b.Name, err)
+			continue
+		}
+		if moved {
+			results = append(results, BranchResult{Name: b.Name, Success: true})
+			log.Success("Fast-forwarded %s", b.Name)
+		}
+	}
+	
+	return results
+}
+
+// conflictMarkers match the lines git writes before, between and after the
+// two sides of a conflict.
+var conflictMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`^<<<<<<<( |$)`),
+	regexp.MustCompile(`^=======$`),
+	regexp.MustCompile(`^>>>>>>>( |$)`),
+}
+
+// ConflictFiles returns the tracked files in the working tree of dir that
+// contain every one of conflictMarkers. A lone line of equals signs, such as
+// a Markdown heading underline, is not enough.
+func ConflictFiles(ctx context.Context, dir string) ([]string, error) {
+	args := []string{"grep", "-l", "-I", "-E", "--all-match"}
+	for _, marker := range conflictMarkers {
+		args = append(args, "-e", marker.String())
+	}
+	
+	// git grep exits with 1 and prints nothing when no file matches.
+	output, err := runGitCommand(ctx, dir, args...)
+	if err != nil {
+		if output == "" && !errors.Is(err, ErrTimeout) && !errors.Is(err, context.Canceled) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	
+	return strings.Split(output, "\n"), nil
+}
+
+// maxFsckErrors is how many of the problems git fsck found are kept in a
+// result.
+const maxFsckErrors = 10
+
+// Fsck checks the objects and refs of the repository and returns the
+// problems git fsck found. Dangling objects are left behind by normal use
+// and are not reported.
+func Fsck(ctx context.Context, dir string) ([]string, error) {
+	output, err := runGitCommand(ctx, dir, "fsck", "--no-progress", "--no-dangling")
+	if err == nil {
+		return nil, nil
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.Canceled) {
+		return nil, err
+	}
+	
+	var problems []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			problems = append(problems, line)
+		}
+	}
+	if len(problems) == 0 {
+		return nil, err
+	}
+	return problems, nil
+}
+
+// checkHealth records whether git fsck found the repository corrupt.
+func checkHealth(ctx context.Context, g Git, repoPath string, result *RepoResult) {
+	log := logger.FromContext(ctx)
+	
+	problems, err := g.Fsck(ctx, repoPath)
+	if err != nil {
+		result.Err = err
+		result.ErrorMessage = fmt.Sprintf("Failed to check the repository: %v", err)
+		log.Error("Failed to check the repository: %v", err)
+		return
+	}
+	
+	result.Success = true
+	if len(problems) == 0 {
+		log.Success("No problems found")
+		return
+	}
+	
+	result.Corrupt = true
+	log.Error("git fsck found %d problems", len(problems))
+	if len(problems) > maxFsckErrors {
+		problems = problems[:maxFsckErrors]
+	}
+	result.FsckErrors = problems
+}
+
+// reportUnpushed records the branches of the repository with commits that
+// are not pushed and whether it has uncommitted changes.
+func reportUnpushed(ctx context.Context, g Git, repoPath string, result *RepoResult) {
+	log := logger.FromContext(ctx)
+	
+	branches, err := g.LocalBranches(ctx, repoPath)
+	if err != nil {
+		result.Err = err
+		result.ErrorMessage = fmt.Sprintf("Failed to list branches: %v", err)
+		log.Error("Failed to list branches: %v", err)
+		return
+	}
+	
+	for _, b := range branches {
+		count, err := g.CountUnpushed(ctx, repoPath, b)
+		if err != nil {
+			result.Err = err
+			result.ErrorMessage = fmt.Sprintf("Failed to count unpushed commits on %s: %v", b.Name, err)
+			log.Error("Failed to count unpushed commits on %s: %v", b.Name, err)
+			return
+		}
+		if count == 0 {
+			continue
+		}
+		
+		result.Unpushed = append(result.Unpushed, UnpushedBranch{Name: b.Name, Upstream: b.Upstream, Commits: count})
+		if b.Upstream == "" {
+			log.Warning("%s has %d commits that are on no remote", b.Name, count)
+		} else {
+			log.Warning("%s is %d commits ahead of its upstream", b.Name, count)
+		}
+	}
+	
+	result.Uncommitted = !g.IsWorkingTreeClean(ctx, repoPath)
+	if result.Uncommitted {
+		log.Warning("Uncommitted changes")
+	}
+	if len(result.Unpushed) == 0 && !result.Uncommitted {
+		log.Success("Everything is pushed")
+	}
+	result.Success = true
+}
+
+// resetToRemote resets branch to the same branch on the remote after a pull
+// could not fast-forward it. localCommits is how many commits the branch had
+// that the remote did not before the pull, or -1 if they could not be
+// counted; they are only discarded with opts.ResetForce.
+func resetToRemote(ctx context.Context, g Git, repoPath, branch string, localCommits int, opts Options, result *RepoResult) bool {
+	log := logger.FromContext(ctx)
+	upstream := remoteName(ctx) + "/" + branch
+	
+	if localCommits != 0 && !opts.ResetForce {
+		result.Err = ErrDiverged
+		if localCommits < 0 {
+			result.ErrorMessage = "Cannot fast-forward and the local commits could not be counted, not resetting"
+		} else {
+			result.ErrorMessage = fmt.Sprintf("Cannot fast-forward, not resetting over %d local commits", localCommits)
+		}
+		log.Error("%s", result.ErrorMessage)
+		return false
+	}
+	
+	if _, err := g.Fetch(ctx, repoPath, opts); err != nil {
+		result.Err = err
+		result.ErrorMessage = fmt.Sprintf("Failed to fetch before resetting: %v", err)
+		log.Error("Failed to fetch before resetting: %v", err)
+		return false
+	}
+	if err := g.ResetHard(ctx, repoPath, upstream); err != nil {
+		result.Err = err
+		result.ErrorMessage = fmt.Sprintf("Failed to reset to %s: %v", upstream, err)
+		log.Error("Failed to reset to %s: %v", upstream, err)
+		return false
+	}
+	
+	result.Reset = true
+	result.Discarded = max(localCommits, 0)
+	if localCommits > 0 {
+		log.Warning("Reset %s to %s, discarding %d local commits", branch, upstream, localCommits)
+	} else {
+		log.Warning("Reset %s to %s", branch, upstream)
+	}
+	return true
+}
+
+// strategy describes how a repository is updated with opts. keepBranch is
+// set when the checked out branch is pulled instead of the default branch.
+func strategy(opts Options, keepBranch bool) string {
+	if opts.FetchOnly {
+		return "fetch-only"
+	}
+	
+	branch := "default-branch"
+	if keepBranch {
+		branch = "current-branch"
+	}
+	pull := "merge"
+	if opts.FFOnly {
+		pull = "ff-only"
+	}
+	return branch + "+" + pull
+}
+
+// repoEvent is the payload of the events about a repository in progress.
+type repoEvent struct {
+	Path    string `json:"path"`
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ProcessRepository updates the repository at repoPath through g. Cancelling
+// ctx aborts the git command that is running and marks the result as
+// cancelled.
+func ProcessRepository(ctx context.Context, g Git, repoPath string, opts Options) (result RepoResult) {
+	// Lines are buffered and printed together once the repository is done so
+	// they do not interleave with other repositories.
+	log := logger.NewBuffer()
+	defer log.Flush()
+	ctx = logger.WithBuffer(ctx, log)
+	
+	// Progress is also reported as events while it happens; the buffer
+	// only delays what is printed.
+	emitter := events.FromContext(ctx)
+	emitter.Emit("repo_start", repoEvent{Path: repoPath})
+	if emitter != nil {
+		log.Observe(func(kind, message string) {
+			emitter.Emit("repo_log", repoEvent{Path: repoPath, Level: kind, Message: message})
+		})
+	}
+	defer func() {
+		emitter.Emit("repo_done", result)
+	}()
+	ctx = withCommandTimeout(ctx, opts.Timeout)
+	
+	// A merge would hide that the branch diverged, so there would be
+	// nothing to reset.
+	if opts.ResetHard {
+		opts.FFOnly = true
+	}
+	
+	result = RepoResult{
+		Path:    repoPath,
+		Success: false,
+	}
+	if opts.NameFromRemote {
+		result.Name = repoName(ctx, g, repoPath)
+	}
+	log.RepoHeader(repoPath, result.Name)
+	
+	repoStart := time.Now()
+	defer func() {
+		result.Duration = time.Since(repoStart)
+	}()
+	
+	defer func() {
+		result.Reason = ReasonFor(result.Err)
+	}()
+	
+	defer func() {
+		if ctx.Err() != nil && !result.Success {
+			result.Cancelled = true
+			result.Err = context.Canceled
+			result.ErrorMessage = "Cancelled"
+			log.Warning("Cancelled")
+		}
+	}()
+	
+	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+		result.Err = ErrNotFound
+		result.ErrorMessage = "Directory does not exist"
+		log.Error("Directory does not exist: %s", repoPath)
+		return result
+	}
+	
+	if !g.IsGitRepo(ctx, repoPath) {
+		result.Err = ErrNotGitRepo
+		result.ErrorMessage = "Not a Git repository"
+		log.Warning("Not a Git repository")
+		return result
+	}
+	
+	// Worktrees of one repository share its refs, so only one of them is
+	// updated at a time.
+	commonDir, unlock := lockRepository(ctx, g, repoPath)
+	defer unlock()
+	
+	if opts.ReportFsck {
+		checkHealth(ctx, g, repoPath, &result)
+		return result
+	}
+	
+	// Commits in repositories without the remote are unpushed too, so the
+	// report does not need one.
+	if opts.ReportAhead {
+		reportUnpushed(ctx, g, repoPath, &result)
+		return result
+	}
+	
+	if _, err := g.RemoteURL(ctx, repoPath); err != nil {
+		result.Err = ErrNoRemote
+		result.ErrorMessage = fmt.Sprintf("No %s remote", remoteName(ctx))
+		log.Warning("No %s remote", remoteName(ctx))
+		return result
+	}
+	
+	result.Shallow = g.IsShallow(ctx, repoPath)
+	if result.Shallow && !opts.Unshallow {
+		log.Debug("Shallow clone, history is incomplete")
+	}
+	if result.Shallow && opts.Unshallow && opts.DryRun {
+		log.Info("Would fetch the full history of this shallow clone")
+	}
+	
+	if opts.FetchOnly {
+		result.Fetched = true
+		result.Strategy = strategy(opts, false)
+		log.Debug("Strategy: %s", result.Strategy)
+		if opts.DryRun {
+			log.Info("Would fetch %s", remoteName(ctx))
+			result.DryRun = true
+			result.Success = true
+			return result
+		}
+		
+		if !unshallow(ctx, g, repoPath, opts, &result) {
+			return result
+		}
+		
+		tagsBefore := listTagsBefore(ctx, g, repoPath, opts)
+		fetchStart := time.Now()
+		var transfer Transfer
+		var err error
+		if alreadyFetched(ctx, commonDir) {
+			log.Debug("Already fetched with another worktree of this repository")
+		} else {
+			transfer, err = g.Fetch(ctx, repoPath, opts)
+		}
+		result.ObjectsReceived, result.BytesReceived = transfer.Objects, transfer.Bytes
+		if err == nil {
+			markFetched(ctx, commonDir)
+		}
+		if err != nil {
+			result.Err = err
+			if errors.Is(err, ErrAuthFailed) {
+				result.ErrorMessage = authFailureMessage(ctx, g, repoPath, err)
+				log.Error("%s", result.ErrorMessage)
+				return result
+			}
+			result.ErrorMessage = fmt.Sprintf("Failed to fetch: %v", err)
+			log.Error("Failed to fetch: %v", err)
+			return result
+		}
+		
+		log.Success("Fetched in %v", time.Since(fetchStart))
+		if tagsBefore != nil {
+			recordTagChanges(ctx, g, repoPath, tagsBefore, &result)
+		}
+		
+		// Show how far the default branch is behind without touching it.
+		if branch, err := defaultBranch(ctx, g, repoPath, opts); err == nil {
+			result.Branch = branch
+			countAheadBehind(ctx, g, repoPath, &result)
+		}
+		
+		result.Success = true
+		return result
+	}
+	
+	// A repository without commits has no branch to check out or pull into
+	// yet; fetching above still works.
+	if _, err := g.HeadCommit(ctx, repoPath); err != nil {
+		result.Skipped = true
+		result.Err = ErrEmptyRepo
+		result.ErrorMessage = "Empty repository, nothing to pull"
+		log.Info("Empty repository, nothing to pull")
+		return result
+	}
+	
+	// Checking out or pulling would get in the way of an unfinished rebase
+	// or merge, so those are left for the user to finish.
+	if operation, ok := g.InProgressOperation(ctx, repoPath); ok {
+		result.Skipped = true
+		result.Err = fmt.Errorf("%w: %s", ErrInProgress, operation)
+		result.ErrorMessage = strings.ToUpper(operation[:1]) + operation[1:] + " in progress"
+		log.Warning("%s, skipping", result.ErrorMessage)
+		countSkippedBehind(ctx, g, repoPath, opts, &result)
+		return result
+	}
+	
+	// With CurrentBranch the checked out branch is kept as long as it tracks
+	// something, otherwise the default branch is used as usual. Linked
+	// worktrees always keep theirs, since the default branch is usually
+	// checked out in the main worktree and git refuses to check it out twice.
+	branch := ""
+	if opts.CurrentBranch || isLinkedWorktree(repoPath) {
+		current, err := g.CurrentBranch(ctx, repoPath)
+		if err == nil && g.UpstreamExists(ctx, repoPath, current) {
+			branch = current
+			log.Debug("Keeping current branch %s", branch)
+		} else if err == nil {
+			log.Debug("Current branch %s has no upstream, using the default branch", current)
+		}
+	}
+	keepBranch := branch != ""
+	
+	if !keepBranch {
+		var err error
+		branch, err = defaultBranch(ctx, g, repoPath, opts)
+		if err != nil {
+			result.Err = err
+			result.ErrorMessage = fmt.Sprintf("Failed to detect default branch: %v", err)
+			log.Error("Failed to detect default branch: %v", err)
+			return result
+		}
+	}
+	result.Branch = branch
+	result.Strategy = strategy(opts, keepBranch)
+	log.Debug("Strategy: %s", result.Strategy)
+	
+	if g.IsDetachedHead(ctx, repoPath) {
+		if !opts.ForceBranch {
+			result.Skipped = true
+			result.Err = ErrDetachedHead
+			result.ErrorMessage = "Detached HEAD"
+			log.Warning("Detached HEAD, skipping")
+			countSkippedBehind(ctx, g, repoPath, opts, &result)
+			return result
+		}
+		log.Debug("HEAD is detached, checking out %s anyway", branch)
+	}
+	
+	dirty := !g.IsWorkingTreeClean(ctx, repoPath)
+	if dirty && !opts.Stash {
+		result.Skipped = true
+		result.Err = ErrDirty
+		result.ErrorMessage = "Uncommitted changes"
+		log.Warning("Uncommitted changes, skipping")
+		countSkippedBehind(ctx, g, repoPath, opts, &result)
+		return result
+	}
+	
+	if opts.DryRun {
+		// Counted against the remote-tracking refs from the last fetch.
+		countAheadBehind(ctx, g, repoPath, &result)
+	}
+	if opts.DryRun && keepBranch {
+		log.Info("Would pull %s", branch)
+		result.DryRun = true
+		result.Success = true
+		return result
+	}
+	if opts.DryRun && opts.AllBranches {
+		log.Info("Would also fast-forward the other branches that track a remote")
+	}
+	if opts.DryRun {
+		log.Info("Would checkout and pull %s", branch)
+		result.DryRun = true
+		result.Success = true
+		return result
+	}
+	
+	if dirty {
+		if err := g.StashPush(ctx, repoPath); err != nil {
+			result.Err = err
+			result.ErrorMessage = fmt.Sprintf("Failed to stash local changes: %v", err)
+			log.Error("Failed to stash local changes: %v", err)
+			return result
+		}
+		log.Debug("Stashed local changes")
+		
+		defer func() {
+			// Restore the changes even when the run was interrupted.
+			if err := g.StashPop(context.WithoutCancel(ctx), repoPath); err != nil {
+				msg := "Failed to restore stashed changes, they are kept in the stash and the working tree may contain conflicts"
+				if result.ErrorMessage != "" {
+					msg = result.ErrorMessage + "; " + msg
+				}
+				result.Success = false
+				result.Err = errors.Join(result.Err, fmt.Errorf("%w: %w", ErrMergeConflict, err))
+				result.ErrorMessage = msg
+				log.Error("Failed to restore stashed changes: %v", err)
+				return
+			}
+			log.Debug("Restored stashed changes")
+		}()
+	}
+	
+	if opts.PreHook != "" && !runHook(ctx, repoPath, branch, "Pre-hook", opts.PreHook, opts, &result) {
+		return result
+	}
+	
+	if !keepBranch {
+		startTime := time.Now()
+		err := g.Checkout(ctx, repoPath, branch)
+		if err != nil {
+			// The remote's HEAD recorded at clone time, or a fallback, may
+			// name a branch that was since renamed, such as master to main.
+			// Ask the remote once more and retry on its answer.
+			if renamed, refreshErr := g.RefreshDefaultBranch(ctx, repoPath, opts); refreshErr == nil && renamed != branch {
+				log.Warning("Default branch appears to have been renamed from %s to %s", branch, renamed)
+				branch, result.Branch = renamed, renamed
+				if _, err = g.Fetch(ctx, repoPath, opts); err == nil {
+					err = g.Checkout(ctx, repoPath, branch)
+				}
+			}
+		}
+		if err != nil {
+			result.Err = err
+			result.ErrorMessage = fmt.Sprintf("Failed to checkout branch %s: %v", branch, err)
+			log.Error("Failed to checkout branch %s: %v", branch, err)
+			return result
+		}
+		log.Debug("Checked out branch %s in %v", branch, time.Since(startTime))
+	}
+	
+	if !unshallow(ctx, g, repoPath, opts, &result) {
+		return result
+	}
+	
+	// Local commits are counted before the pull moves the remote-tracking
+	// branch, so history rewritten on the remote is not taken for them.
+	localCommits := -1
+	if opts.ResetHard {
+		if ahead, _, err := g.AheadBehind(ctx, repoPath, branch); err == nil {
+			localCommits = ahead
+		}
+	}
+	
+	headBefore, _ := g.HeadCommit(ctx, repoPath)
+	tagsBefore := listTagsBefore(ctx, g, repoPath, opts)
+	pullStart := time.Now()
+	var transfer Transfer
+	var err error
+	if alreadyFetched(ctx, commonDir) {
+		log.Debug("Already fetched with another worktree of this repository, merging %s", branch)
+		err = g.Merge(ctx, repoPath, opts)
+	} else {
+		transfer, err = g.Pull(ctx, repoPath, opts)
+	}
+	result.ObjectsReceived, result.BytesReceived = transfer.Objects, transfer.Bytes
+	if err == nil || errors.Is(err, ErrDiverged) {
+		markFetched(ctx, commonDir)
+	}
+	if errors.Is(err, ErrDiverged) && opts.ResetHard {
+		if !resetToRemote(ctx, g, repoPath, branch, localCommits, opts, &result) {
+			return result
+		}
+		err = nil
+	}
+	if err != nil {
+		result.Err = err
+		if errors.Is(err, ErrDiverged) {
+			result.ErrorMessage = fmt.Sprintf("Cannot fast-forward, diverged from %s", remoteName(ctx))
+			log.Error("Cannot fast-forward %s, it has diverged from %s", branch, remoteName(ctx))
+			return result
+		}
+		if errors.Is(err, ErrAuthFailed) {
+			result.ErrorMessage = authFailureMessage(ctx, g, repoPath, err)
+			log.Error("%s", result.ErrorMessage)
+			return result
+		}
+		result.ErrorMessage = fmt.Sprintf("Failed to pull: %v", err)
+		log.Error("Failed to pull: %v", err)
+		return result
+	}
+	
+	headAfter, err := g.HeadCommit(ctx, repoPath)
+	result.Changed = err == nil && headAfter != headBefore
+	if result.Changed {
+		log.Success("Pulled %s in %v", branch, time.Since(pullStart))
+	} else {
+		log.Success("%s is already up to date (%v)", branch, time.Since(pullStart))
+	}
+	if tagsBefore != nil {
+		recordTagChanges(ctx, g, repoPath, tagsBefore, &result)
+	}
+	
+	if opts.Submodules {
+		submodulesStart := time.Now()
+		if err := g.UpdateSubmodules(ctx, repoPath); err != nil {
+			result.Err = err
+			result.ErrorMessage = fmt.Sprintf("Failed to update submodules: %v", err)
+			log.Error("Failed to update submodules: %v", err)
+			return result
+		}
+		log.Debug("Updated submodules in %v", time.Since(submodulesStart))
+	}
+	
+	// Line ending or file mode settings can make files look modified right
+	// after checking them out. Stashed changes are expected to be there.
+	if opts.VerifyClean != "" && !dirty {
+		changed, err := g.ModifiedFiles(ctx, repoPath)
+		if err != nil {
+			log.Warning("Failed to check the working tree after pulling: %v", err)
+		} else if len(changed) > 0 {
+			result.ChangedFiles = changed
+			log.Warning("Pulling left %d modified files: %s", len(changed), strings.Join(changed, ", "))
+			if opts.VerifyClean == "fail" {
+				result.Err = ErrBecameDirty
+				result.ErrorMessage = fmt.Sprintf("Pulling left %d modified files", len(changed))
+				return result
+			}
+		}
+	}
+	
+	if opts.CheckConflicts {
+		files, err := g.ConflictFiles(ctx, repoPath)
+		if err != nil {
+			log.Warning("Failed to look for conflict markers: %v", err)
+		} else if len(files) > 0 {
+			result.ConflictFiles = files
+			result.Err = ErrConflictMarkers
+			result.ErrorMessage = fmt.Sprintf("Conflict markers left in %d files", len(files))
+			log.Error("Conflict markers left in %d files: %s", len(files), strings.Join(files, ", "))
+			return result
+		}
+	}
+	
+	if opts.PostHook != "" {
+		if result.Changed {
+			if !runHook(ctx, repoPath, branch, "Post-hook", opts.PostHook, opts, &result) {
+				return result
+			}
+		} else {
+			log.Debug("No new commits, not running the post-hook")
+		}
+	}
+	
+	countAheadBehind(ctx, g, repoPath, &result)
+	
+	if opts.AllBranches {
+		result.Branches = fastForwardOtherBranches(ctx, g, repoPath, branch)
+	}
+	
+	// A failed prune leaves the branch up to date, so it is only reported.
+	if opts.Prune {
+		pruned, err := g.PruneRemote(ctx, repoPath, opts)
+		if err != nil {
+			log.Warning("Failed to prune stale remote-tracking branches: %v", err)
+		} else if pruned > 0 {
+			result.Pruned = pruned
+			log.Info("Pruned %d stale remote-tracking branches", pruned)
+		}
+	}
+	
+	if opts.GC {
+		collectGarbage(ctx, g, repoPath, &result)
 	}
 	
-	logger.Success("Pulled %s in %v", branch, time.Since(pullStart))
 	result.Success = true
 	return result
 }
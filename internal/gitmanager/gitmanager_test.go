@@ -0,0 +1,234 @@
+package gitmanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// git runs git in dir and fails the test when it does not succeed.
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// commit adds a commit to the repository at dir and returns its hash.
+func commit(t *testing.T, dir, message string) string {
+	t.Helper()
+	
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(message+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, "add", "file.txt")
+	git(t, dir, "commit", "-q", "-m", message)
+	return git(t, dir, "rev-parse", "HEAD")
+}
+
+// isolateGit skips the test when git is not installed and keeps the user's
+// git configuration out of the repositories the test creates.
+func isolateGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "Test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+}
+
+// upstreamOnlyRepo returns a clone whose only remote is called upstream,
+// one commit behind it, and the commit it is behind.
+func upstreamOnlyRepo(t *testing.T) (repo, latest string) {
+	t.Helper()
+	isolateGit(t)
+	
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote.git")
+	seed := filepath.Join(dir, "seed")
+	repo = filepath.Join(dir, "repo")
+	
+	git(t, dir, "init", "-q", "--bare", remote)
+	git(t, remote, "symbolic-ref", "HEAD", "refs/heads/main")
+	git(t, dir, "clone", "-q", remote, seed)
+	git(t, seed, "checkout", "-q", "-b", "main")
+	commit(t, seed, "first")
+	git(t, seed, "push", "-q", "origin", "main")
+	
+	git(t, dir, "clone", "-q", "--origin", "upstream", remote, repo)
+	latest = commit(t, seed, "second")
+	git(t, seed, "push", "-q", "origin", "main")
+	
+	return repo, latest
+}
+
+func TestUpstreamOnlyRemote(t *testing.T) {
+	repo, _ := upstreamOnlyRepo(t)
+	ctx := WithRemote(context.Background(), "upstream")
+	
+	if _, err := GetRemoteURL(context.Background(), repo); !errors.Is(err, ErrNoRemote) {
+		t.Errorf("GetRemoteURL without -remote: got %v, want ErrNoRemote", err)
+	}
+	if url, err := GetRemoteURL(ctx, repo); err != nil || !strings.HasSuffix(url, "remote.git") {
+		t.Errorf("GetRemoteURL = %q, %v, want the upstream URL", url, err)
+	}
+	
+	branch, err := DetectDefaultBranch(ctx, repo, nil, nil)
+	if err != nil || branch != "main" {
+		t.Errorf("DetectDefaultBranch = %q, %v, want main", branch, err)
+	}
+	
+	// Without the symbolic ref the branch is asked from the remote.
+	git(t, repo, "symbolic-ref", "--delete", "refs/remotes/upstream/HEAD")
+	branch, err = DetectDefaultBranch(ctx, repo, nil, nil)
+	if err != nil || branch != "main" {
+		t.Errorf("DetectDefaultBranch without upstream/HEAD = %q, %v, want main", branch, err)
+	}
+}
+
+func TestUpstreamOnlyFetch(t *testing.T) {
+	repo, latest := upstreamOnlyRepo(t)
+	ctx := WithRemote(context.Background(), "upstream")
+	
+	if _, err := Fetch(ctx, repo, Options{}); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if got := git(t, repo, "rev-parse", "upstream/main"); got != latest {
+		t.Errorf("upstream/main = %s after Fetch, want %s", got, latest)
+	}
+}
+
+func TestProcessRepositoryUpstreamOnly(t *testing.T) {
+	repo, latest := upstreamOnlyRepo(t)
+	
+	result := ProcessRepository(context.Background(), ExecGit{}, repo, Options{})
+	if result.Success || result.Reason != ReasonNoRemote {
+		t.Errorf("without -remote: success %v, reason %q, want reason %q", result.Success, result.Reason, ReasonNoRemote)
+	}
+	
+	ctx := WithRemote(context.Background(), "upstream")
+	result = ProcessRepository(ctx, ExecGit{}, repo, Options{FFOnly: true})
+	if !result.Success {
+		t.Fatalf("ProcessRepository failed: %v (%s)", result.Err, result.ErrorMessage)
+	}
+	if result.Branch != "main" {
+		t.Errorf("branch = %q, want main", result.Branch)
+	}
+	if got := git(t, repo, "rev-parse", "HEAD"); got != latest {
+		t.Errorf("HEAD = %s after the update, want %s", got, latest)
+	}
+}
+
+func TestSharedFetchWorktrees(t *testing.T) {
+	repo, latest := upstreamOnlyRepo(t)
+	git(t, repo, "remote", "rename", "upstream", "origin")
+	worktree := filepath.Join(t.TempDir(), "feature")
+	git(t, repo, "worktree", "add", "-q", "-b", "feature", worktree, "origin/main")
+	git(t, worktree, "branch", "-q", "--set-upstream-to", "origin/main")
+	
+	// The worktree fetches, the main worktree only merges what it fetched.
+	ctx := WithSharedFetch(context.Background())
+	for _, dir := range []string{worktree, repo} {
+		result := ProcessRepository(ctx, ExecGit{}, dir, Options{FFOnly: true})
+		if !result.Success {
+			t.Fatalf("ProcessRepository(%s) failed: %v (%s)", dir, result.Err, result.ErrorMessage)
+		}
+		if got := git(t, dir, "rev-parse", "HEAD"); got != latest {
+			t.Errorf("HEAD of %s = %s after the update, want %s", dir, got, latest)
+		}
+	}
+}
+
+func TestDefaultBranchPattern(t *testing.T) {
+	isolateGit(t)
+	pattern := regexp.MustCompile(`^release/`)
+	
+	tests := []struct {
+		name      string
+		branches  []string
+		fallbacks []string
+		want      string
+	}{
+		{name: "only the pattern matches", branches: []string{"release/2.0"}, fallbacks: []string{"main", "master"}, want: "release/2.0"},
+		{name: "the first match in name order", branches: []string{"release/2.0", "release/1.0", "topic"}, fallbacks: []string{"main"}, want: "release/1.0"},
+		{name: "the list wins over the pattern", branches: []string{"release/1.0", "trunk"}, fallbacks: []string{"main", "trunk"}, want: "trunk"},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a remote only the list and the pattern are left.
+			repo := t.TempDir()
+			git(t, repo, "init", "-q", "-b", tt.branches[0])
+			commit(t, repo, "first")
+			for _, branch := range tt.branches[1:] {
+				git(t, repo, "branch", branch)
+			}
+			
+			for _, g := range []Git{ExecGit{}, GoGit{}} {
+				branch, err := g.DefaultBranch(context.Background(), repo, tt.fallbacks, pattern)
+				if err != nil || branch != tt.want {
+					t.Errorf("%T.DefaultBranch = %q, %v, want %q", g, branch, err, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRemoteURL(t *testing.T) {
+	tests := []struct {
+		url               string
+		host, owner, repo string
+		wantErr           bool
+	}{
+		{url: "git@github.com:owner/repo.git", host: "github.com", owner: "owner", repo: "repo"},
+		{url: "git@GitHub.com:owner/repo", host: "github.com", owner: "owner", repo: "repo"},
+		{url: "github.com:owner/repo.git", host: "github.com", owner: "owner", repo: "repo"},
+		{url: "git@gitlab.com:group/subgroup/repo.git", host: "gitlab.com", owner: "group/subgroup", repo: "repo"},
+		{url: "ssh://git@github.com/owner/repo.git", host: "github.com", owner: "owner", repo: "repo"},
+		{url: "ssh://git@git.example.com:2222/owner/repo.git", host: "git.example.com", owner: "owner", repo: "repo"},
+		{url: "git+ssh://git@example.com/owner/repo.git/", host: "example.com", owner: "owner", repo: "repo"},
+		{url: "https://github.com/owner/repo.git", host: "github.com", owner: "owner", repo: "repo"},
+		{url: "https://user@example.com:8443/owner/repo", host: "example.com", owner: "owner", repo: "repo"},
+		{url: "http://example.com/group/sub/repo.git", host: "example.com", owner: "group/sub", repo: "repo"},
+		{url: "https://github.com/repo.git", wantErr: true},
+		{url: "https://github.com/owner/", wantErr: true},
+		{url: "git@github.com:repo.git", wantErr: true},
+		{url: "/srv/git/owner/repo.git", wantErr: true},
+		{url: "../owner/repo.git", wantErr: true},
+		{url: `C:\repos\owner\repo.git`, wantErr: true},
+		{url: "file:///srv/git/owner/repo.git", wantErr: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			host, owner, repo, err := ParseRemoteURL(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseRemoteURL(%q) = %q, %q, %q, want an error", tt.url, host, owner, repo)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRemoteURL(%q): %v", tt.url, err)
+			}
+			if host != tt.host || owner != tt.owner || repo != tt.repo {
+				t.Errorf("ParseRemoteURL(%q) = %q, %q, %q, want %q, %q, %q", tt.url, host, owner, repo, tt.host, tt.owner, tt.repo)
+			}
+		})
+	}
+}
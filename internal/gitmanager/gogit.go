@@ -0,0 +1,979 @@
+package gitmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+	"github.com/lyubomir-bozhinov/pullio/internal/utils"
+)
+
+// GoGit implements Git with go-git, so no git binary is needed. SSH remotes
+// authenticate through the SSH agent and HTTPS remotes without credentials.
+// Stashing, unshallowing, merging diverged branches, maintenance and
+// checking for corruption are not supported.
+type GoGit struct{}
+
+// unsupported is returned for operations go-git cannot perform.
+func unsupported(operation string) error {
+	return fmt.Errorf("%s is not supported by the go-git backend", operation)
+}
+
+// inProcessFileTransport makes go-git serve local remotes itself instead of
+// running git-upload-pack, which comes with the git binary.
+var inProcessFileTransport sync.Once
+
+func openRepository(dir string) (*gogit.Repository, error) {
+	inProcessFileTransport.Do(func() {
+		client.InstallProtocol("file", server.DefaultServer)
+	})
+	
+	return gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{EnableDotGitCommonDir: true})
+}
+
+// goGitContext applies the command timeout carried by ctx to an operation.
+func goGitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout, _ := ctx.Value(timeoutKey{}).(time.Duration); timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	
+	return context.WithCancel(ctx)
+}
+
+// goGitError wraps an error from go-git in the errors of this package that
+// describe it, like runGitCommand does for the output of git.
+func goGitError(ctx context.Context, operation string, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s: %w", operation, ErrTimeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%s: %w", operation, context.Canceled)
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed),
+		errors.Is(err, transport.ErrInvalidAuthMethod):
+		return fmt.Errorf("%s: %w: %v", operation, ErrAuthFailed, err)
+	}
+	
+	if known := classifyOutput(err.Error()); known != nil {
+		return fmt.Errorf("%s: %w: %v", operation, known, err)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%s: %w: %v", operation, ErrNetwork, err)
+	}
+	return fmt.Errorf("%s: %w", operation, err)
+}
+
+// remoteAuth returns the authentication for remoteURL: the SSH agent for SSH
+// remotes and none otherwise.
+func remoteAuth(remoteURL string) (transport.AuthMethod, error) {
+	if !IsSSHURL(remoteURL) {
+		return nil, nil
+	}
+	
+	user := "git"
+	if endpoint, err := transport.NewEndpoint(remoteURL); err == nil && endpoint.User != "" {
+		user = endpoint.User
+	}
+	auth, err := gitssh.NewSSHAgentAuth(user)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	return auth, nil
+}
+
+// remote returns the named remote of repo and its authentication.
+func remote(repo *gogit.Repository, name string) (*gogit.Remote, transport.AuthMethod, error) {
+	r, err := repo.Remote(name)
+	if errors.Is(err, gogit.ErrRemoteNotFound) {
+		return nil, nil, ErrNoRemote
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	var auth transport.AuthMethod
+	if urls := r.Config().URLs; len(urls) > 0 {
+		if auth, err = remoteAuth(urls[0]); err != nil {
+			return nil, nil, err
+		}
+	}
+	return r, auth, nil
+}
+
+// gitDirs returns the git directory of the worktree at dir and the directory
+// it shares with the other worktrees of the repository.
+func gitDirs(dir string) (gitDir, commonDir string, err error) {
+	gitDir = filepath.Join(dir, ".git")
+	info, err := os.Stat(gitDir)
+	if err != nil {
+		return "", "", err
+	}
+	
+	// A linked worktree or a separate git directory has a .git file pointing
+	// at the git directory. Worktrees name the common directory in its
+	// commondir file.
+	if !info.IsDir() {
+		if gitDir, err = utils.ReadGitFile(gitDir); err != nil {
+			return "", "", err
+		}
+	}
+	
+	commonDir = gitDir
+	if content, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = strings.TrimSpace(string(content))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		commonDir = filepath.Clean(commonDir)
+	}
+	
+	return gitDir, commonDir, nil
+}
+
+func (GoGit) IsGitRepo(ctx context.Context, dir string) bool {
+	_, err := openRepository(dir)
+	return err == nil
+}
+
+func (GoGit) LastActivity(ctx context.Context, dir string) (time.Time, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return time.Time{}, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return time.Time{}, err
+	}
+	last := commit.Committer.When
+	
+	if gitDir, _, err := gitDirs(dir); err == nil {
+		if info, err := os.Stat(filepath.Join(gitDir, "HEAD")); err == nil && info.ModTime().After(last) {
+			last = info.ModTime()
+		}
+	}
+	
+	return last, nil
+}
+
+// HasSSHCommand always reports false: go-git connects over SSH itself,
+// through the agent, so core.sshCommand does not apply.
+func (GoGit) HasSSHCommand(ctx context.Context, dir string) bool {
+	return false
+}
+
+func (GoGit) RemoteURL(ctx context.Context, dir string) (string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	
+	r, err := repo.Remote(remoteName(ctx))
+	if errors.Is(err, gogit.ErrRemoteNotFound) {
+		return "", ErrNoRemote
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(r.Config().URLs) == 0 {
+		return "", ErrNoRemote
+	}
+	return r.Config().URLs[0], nil
+}
+
+func (GoGit) CommonDir(ctx context.Context, dir string) (string, error) {
+	_, commonDir, err := gitDirs(dir)
+	return commonDir, err
+}
+
+func (GoGit) IsShallow(ctx context.Context, dir string) bool {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return false
+	}
+	
+	shallow, err := repo.Storer.Shallow()
+	return err == nil && len(shallow) > 0
+}
+
+func (GoGit) Unshallow(ctx context.Context, dir string, opts Options) error {
+	return unsupported("unshallowing")
+}
+
+func (GoGit) InProgressOperation(ctx context.Context, dir string) (string, bool) {
+	gitDir, _, err := gitDirs(dir)
+	if err != nil {
+		return "", false
+	}
+	
+	return operationInProgress(gitDir)
+}
+
+func (GoGit) DefaultBranch(ctx context.Context, dir string, fallbacks []string, pattern *regexp.Regexp) (string, error) {
+	log := logger.FromContext(ctx)
+	
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	
+	name := remoteName(ctx)
+	
+	// Method 1: Check symbolic ref for <remote>/HEAD
+	ref, err := repo.Reference(plumbing.NewRemoteHEADReferenceName(name), false)
+	if err == nil && ref.Type() == plumbing.SymbolicReference {
+		branch := strings.TrimPrefix(ref.Target().String(), "refs/remotes/"+name+"/")
+		log.Debug("Found default branch via %s/HEAD: %s", name, branch)
+		return branch, nil
+	}
+	
+	// Method 2: Ask the remote which branch its HEAD points at
+	if r, auth, err := remote(repo, name); err == nil {
+		listCtx, cancel := goGitContext(ctx)
+		refs, err := r.ListContext(listCtx, &gogit.ListOptions{Auth: auth})
+		cancel()
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+				log.Debug("Found default branch via the remote: %s", ref.Target().Short())
+				return ref.Target().Short(), nil
+			}
+		}
+		if err != nil {
+			log.Debug("Failed to list the references of %s: %v", name, err)
+		}
+	}
+	
+	// Method 3: Check for common branch names
+	for _, branch := range fallbacks {
+		if _, err := repo.Reference(plumbing.NewBranchReferenceName(branch), false); err == nil {
+			log.Debug("Found default branch via fallback: %s", branch)
+			return branch, nil
+		}
+	}
+	
+	// Method 4: Match local branch names against the pattern
+	if pattern != nil {
+		if iter, err := repo.Branches(); err == nil {
+			var branches []string
+			iter.ForEach(func(ref *plumbing.Reference) error {
+				branches = append(branches, ref.Name().Short())
+				return nil
+			})
+			if branch, ok := matchBranch(branches, pattern); ok {
+				log.Debug("Found default branch via pattern: %s", branch)
+				return branch, nil
+			}
+		}
+	}
+	
+	return "", fmt.Errorf("could not detect default branch")
+}
+
+func (GoGit) RefreshDefaultBranch(ctx context.Context, dir string, opts Options) (string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	name := remoteName(ctx)
+	r, auth, err := remote(repo, name)
+	if err != nil {
+		return "", err
+	}
+	
+	listCtx, cancel := goGitContext(ctx)
+	defer cancel()
+	refs, err := r.ListContext(listCtx, &gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return "", goGitError(listCtx, "ls-remote "+name, err)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			branch := ref.Target().Short()
+			head := plumbing.NewSymbolicReference(plumbing.NewRemoteHEADReferenceName(name), plumbing.NewRemoteReferenceName(name, branch))
+			return branch, repo.Storer.SetReference(head)
+		}
+	}
+	return "", fmt.Errorf("%s does not report its default branch", name)
+}
+
+func (GoGit) CurrentBranch(ctx context.Context, dir string) (string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", err
+	}
+	if head.Type() != plumbing.SymbolicReference {
+		return "", errors.New("HEAD is detached")
+	}
+	return head.Target().Short(), nil
+}
+
+func (GoGit) UpstreamExists(ctx context.Context, dir, branch string) bool {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return false
+	}
+	
+	cfg, err := repo.Config()
+	if err != nil {
+		return false
+	}
+	b, ok := cfg.Branches[branch]
+	return ok && b.Remote != "" && b.Merge != ""
+}
+
+func (g GoGit) IsDetachedHead(ctx context.Context, dir string) bool {
+	_, err := g.CurrentBranch(ctx, dir)
+	return err != nil
+}
+
+func (g GoGit) IsWorkingTreeClean(ctx context.Context, dir string) bool {
+	changed, err := g.ModifiedFiles(ctx, dir)
+	return err == nil && len(changed) == 0
+}
+
+// ModifiedFiles lists the tracked files that differ from HEAD in the index
+// or the working tree. Untracked files are left out.
+func (GoGit) ModifiedFiles(ctx context.Context, dir string) ([]string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	
+	status, err := wt.Status()
+	if err != nil {
+		return nil, err
+	}
+	
+	var changed []string
+	for name, s := range status {
+		if s.Worktree == gogit.Untracked {
+			continue
+		}
+		if s.Staging != gogit.Unmodified || s.Worktree != gogit.Unmodified {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	
+	return changed, nil
+}
+
+func (GoGit) StashPush(ctx context.Context, dir string) error {
+	return unsupported("stashing")
+}
+
+func (GoGit) StashPop(ctx context.Context, dir string) error {
+	return unsupported("stashing")
+}
+
+// Checkout checks out branch. A branch that only exists on the remote set
+// with WithRemote is created to track it, as git checkout does.
+func (GoGit) Checkout(ctx context.Context, dir, branch string) error {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	
+	name := plumbing.NewBranchReferenceName(branch)
+	if _, err := repo.Reference(name, false); err == nil {
+		return wt.Checkout(&gogit.CheckoutOptions{Branch: name})
+	}
+	
+	remote := remoteName(ctx)
+	upstream, err := repo.Reference(plumbing.NewRemoteReferenceName(remote, branch), true)
+	if err != nil {
+		return fmt.Errorf("branch %s does not exist locally or on %s", branch, remote)
+	}
+	if err := wt.Checkout(&gogit.CheckoutOptions{Branch: name, Hash: upstream.Hash(), Create: true}); err != nil {
+		return err
+	}
+	return repo.CreateBranch(&config.Branch{Name: branch, Remote: remote, Merge: name})
+}
+
+func (GoGit) ResetHard(ctx context.Context, dir, ref string) error {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return err
+	}
+	return wt.Reset(&gogit.ResetOptions{Commit: *hash, Mode: gogit.HardReset})
+}
+
+func (GoGit) HeadCommit(ctx context.Context, dir string) (string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return "", err
+	}
+	
+	head, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	return head.Hash().String(), nil
+}
+
+// fetch fetches one remote of repo, treating an up to date remote as
+// success.
+func fetch(ctx context.Context, repo *gogit.Repository, name string, fetchOpts gogit.FetchOptions) error {
+	r, auth, err := remote(repo, name)
+	if err != nil {
+		return err
+	}
+	
+	ctx, cancel := goGitContext(ctx)
+	defer cancel()
+	
+	fetchOpts.RemoteName = name
+	fetchOpts.Auth = auth
+	err = r.FetchContext(ctx, &fetchOpts)
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return goGitError(ctx, "fetch "+name, err)
+	}
+	return nil
+}
+
+// progress returns where the transfer progress of the repository at dir is
+// shown: as debug output while it happens, when that is enabled.
+func progress(dir string) io.Writer {
+	if !logger.Enabled(logger.LevelDebug) {
+		return nil
+	}
+	return logger.LiveWriter(filepath.Base(dir))
+}
+
+// tagOptions returns the fetch options that bring in all tags, replacing
+// moved ones, or no tags, as opts asks, and otherwise follow the tags of
+// fetched commits. go-git does not read the remote's tagOpt setting.
+func tagOptions(opts Options) gogit.FetchOptions {
+	switch {
+	case opts.Tags:
+		return gogit.FetchOptions{Tags: gogit.AllTags, Force: true}
+	case opts.NoTags:
+		return gogit.FetchOptions{Tags: gogit.NoTags}
+	}
+	
+	return gogit.FetchOptions{Tags: gogit.TagFollowing}
+}
+
+// Fetch fetches the remote set with WithRemote. What was received is not
+// counted.
+func (GoGit) Fetch(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	logger.FromContext(ctx).Debug("Fetching %s of %s with go-git", remoteName(ctx), dir)
+	
+	repo, err := openRepository(dir)
+	if err != nil {
+		return Transfer{}, err
+	}
+	
+	fetchOpts := tagOptions(opts)
+	fetchOpts.Prune = true
+	fetchOpts.Progress = progress(dir)
+	if err := fetch(ctx, repo, remoteName(ctx), fetchOpts); err != nil {
+		return Transfer{}, err
+	}
+	return Transfer{}, nil
+}
+
+// Pull fast-forwards the checked out branch to its upstream. Branches that
+// have diverged fail with ErrDiverged, since go-git cannot merge. What was
+// received is not counted.
+func (GoGit) Pull(ctx context.Context, dir string, opts Options) (Transfer, error) {
+	logger.FromContext(ctx).Debug("Pulling %s with go-git", dir)
+	
+	repo, err := openRepository(dir)
+	if err != nil {
+		return Transfer{}, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return Transfer{}, err
+	}
+	
+	pullRemote, merge, err := pullSource(ctx, repo)
+	if err != nil {
+		return Transfer{}, err
+	}
+	_, auth, err := remote(repo, pullRemote)
+	if err != nil {
+		return Transfer{}, err
+	}
+	
+	// go-git always follows tags when pulling, so without tags the remote
+	// is fetched first and the pull finds nothing left to bring in.
+	if opts.NoTags {
+		if err := fetch(ctx, repo, pullRemote, tagOptions(opts)); err != nil {
+			return Transfer{}, err
+		}
+	}
+	
+	pullCtx, cancel := goGitContext(ctx)
+	defer cancel()
+	err = wt.PullContext(pullCtx, &gogit.PullOptions{
+		RemoteName:    pullRemote,
+		ReferenceName: merge,
+		Auth:          auth,
+		Progress:      progress(dir),
+	})
+	switch {
+	case errors.Is(err, gogit.NoErrAlreadyUpToDate):
+	case errors.Is(err, gogit.ErrNonFastForwardUpdate):
+		return Transfer{}, ErrDiverged
+	case err != nil:
+		return Transfer{}, goGitError(pullCtx, "pull", err)
+	}
+	
+	if opts.Tags {
+		return Transfer{}, fetch(ctx, repo, pullRemote, tagOptions(opts))
+	}
+	return Transfer{}, nil
+}
+
+// Merge fast-forwards the checked out branch to its upstream as it was last
+// fetched. Branches that have diverged fail with ErrDiverged, as with Pull.
+func (GoGit) Merge(ctx context.Context, dir string, opts Options) error {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	
+	pullRemote, merge, err := pullSource(ctx, repo)
+	if err != nil {
+		return err
+	}
+	upstream, err := repo.Reference(plumbing.NewRemoteReferenceName(pullRemote, merge.Short()), true)
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if head.Hash() == upstream.Hash() {
+		return nil
+	}
+	
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	upstreamCommit, err := repo.CommitObject(upstream.Hash())
+	if err != nil {
+		return err
+	}
+	if ok, err := headCommit.IsAncestor(upstreamCommit); err != nil || !ok {
+		// A branch that is only ahead of its upstream has nothing to pull.
+		if ahead, err := upstreamCommit.IsAncestor(headCommit); err == nil && ahead {
+			return nil
+		}
+		return ErrDiverged
+	}
+	
+	return wt.Reset(&gogit.ResetOptions{Commit: upstream.Hash(), Mode: gogit.MergeReset})
+}
+
+// pullSource returns the remote and the branch on it that the checked out
+// branch of repo is pulled from. Like git pull, it follows the upstream of
+// the branch unless another remote than DefaultRemote was asked for.
+func pullSource(ctx context.Context, repo *gogit.Repository) (string, plumbing.ReferenceName, error) {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return "", "", err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", "", err
+	}
+	if head.Type() != plumbing.SymbolicReference {
+		return "", "", ErrDetachedHead
+	}
+	
+	pullRemote, merge := remoteName(ctx), head.Target()
+	if pullRemote == DefaultRemote {
+		upstream, ok := cfg.Branches[head.Target().Short()]
+		if !ok || upstream.Merge == "" {
+			return "", "", fmt.Errorf("%s has no upstream branch", head.Target().Short())
+		}
+		merge = upstream.Merge
+		if upstream.Remote != "" {
+			pullRemote = upstream.Remote
+		}
+	}
+	return pullRemote, merge, nil
+}
+
+func (GoGit) UpdateSubmodules(ctx context.Context, dir string) error {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	submodules, err := wt.Submodules()
+	if err != nil || len(submodules) == 0 {
+		return err
+	}
+	
+	var auth transport.AuthMethod
+	if _, auth, err = remote(repo, remoteName(ctx)); err != nil && !errors.Is(err, ErrNoRemote) {
+		return err
+	}
+	
+	ctx, cancel := goGitContext(ctx)
+	defer cancel()
+	err = submodules.UpdateContext(ctx, &gogit.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: gogit.DefaultSubmoduleRecursionDepth,
+		Auth:              auth,
+	})
+	if err != nil {
+		return goGitError(ctx, "submodule update", err)
+	}
+	return nil
+}
+
+func (GoGit) ListTags(ctx context.Context, dir string) (map[string]string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	iter, err := repo.Tags()
+	if err != nil {
+		return nil, err
+	}
+	
+	tags := make(map[string]string)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tags[ref.Name().Short()] = ref.Hash().String()
+		return nil
+	})
+	return tags, err
+}
+
+// ancestors returns the commits reachable from hash.
+func ancestors(repo *gogit.Repository, hash plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	
+	seen := make(map[plumbing.Hash]bool)
+	err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+	return seen, err
+}
+
+func (GoGit) AheadBehind(ctx context.Context, dir, branch string) (ahead, behind int, err error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	local, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return 0, 0, err
+	}
+	upstream, err := repo.Reference(plumbing.NewRemoteReferenceName(remoteName(ctx), branch), true)
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	localCommits, err := ancestors(repo, local.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	upstreamCommits, err := ancestors(repo, upstream.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	
+	for hash := range localCommits {
+		if !upstreamCommits[hash] {
+			ahead++
+		}
+	}
+	for hash := range upstreamCommits {
+		if !localCommits[hash] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+func (GoGit) LocalBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := repo.Branches()
+	if err != nil {
+		return nil, err
+	}
+	
+	var branches []TrackingBranch
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		branch := TrackingBranch{Name: ref.Name().Short()}
+		if b, ok := cfg.Branches[branch.Name]; ok && b.Remote != "" && b.Merge != "" {
+			branch.Upstream = plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short()).String()
+		}
+		branches = append(branches, branch)
+		return nil
+	})
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+	
+	return branches, err
+}
+
+func (g GoGit) TrackingBranches(ctx context.Context, dir string) ([]TrackingBranch, error) {
+	branches, err := g.LocalBranches(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	
+	var tracking []TrackingBranch
+	for _, b := range branches {
+		if b.Upstream != "" {
+			tracking = append(tracking, b)
+		}
+	}
+	
+	return tracking, nil
+}
+
+func (GoGit) CountUnpushed(ctx context.Context, dir string, b TrackingBranch) (int, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return 0, err
+	}
+	local, err := repo.Reference(plumbing.NewBranchReferenceName(b.Name), true)
+	if err != nil {
+		return 0, err
+	}
+	
+	// Commits are pushed when the upstream, or without one any remote, has
+	// them.
+	var pushedTips []plumbing.Hash
+	if b.Upstream != "" {
+		upstream, err := repo.Reference(plumbing.ReferenceName(b.Upstream), true)
+		if err != nil {
+			return 0, err
+		}
+		pushedTips = append(pushedTips, upstream.Hash())
+	} else {
+		iter, err := repo.References()
+		if err != nil {
+			return 0, err
+		}
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Name().IsRemote() && ref.Type() == plumbing.HashReference {
+				pushedTips = append(pushedTips, ref.Hash())
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	
+	pushed := make(map[plumbing.Hash]bool)
+	for _, tip := range pushedTips {
+		commits, err := ancestors(repo, tip)
+		if err != nil {
+			return 0, err
+		}
+		for hash := range commits {
+			pushed[hash] = true
+		}
+	}
+	
+	commits, err := ancestors(repo, local.Hash())
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for hash := range commits {
+		if !pushed[hash] {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (GoGit) FastForwardBranch(ctx context.Context, dir string, b TrackingBranch) (bool, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return false, err
+	}
+	local, err := repo.Reference(plumbing.NewBranchReferenceName(b.Name), false)
+	if err != nil {
+		return false, err
+	}
+	upstream, err := repo.Reference(plumbing.ReferenceName(b.Upstream), true)
+	if err != nil {
+		return false, err
+	}
+	if local.Hash() == upstream.Hash() {
+		return false, nil
+	}
+	
+	localCommit, err := repo.CommitObject(local.Hash())
+	if err != nil {
+		return false, err
+	}
+	upstreamCommit, err := repo.CommitObject(upstream.Hash())
+	if err != nil {
+		return false, err
+	}
+	if ok, err := localCommit.IsAncestor(upstreamCommit); err != nil || !ok {
+		// A branch that is only ahead of its upstream has nothing to pull.
+		if ahead, err := upstreamCommit.IsAncestor(localCommit); err == nil && ahead {
+			return false, nil
+		}
+		return false, ErrDiverged
+	}
+	
+	err = repo.Storer.CheckAndSetReference(plumbing.NewHashReference(local.Name(), upstream.Hash()), local)
+	return err == nil, err
+}
+
+// PruneRemote fetches the remote set with WithRemote with pruning and counts
+// the remote-tracking branches that are gone afterwards.
+func (GoGit) PruneRemote(ctx context.Context, dir string, opts Options) (int, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return 0, err
+	}
+	
+	name := remoteName(ctx)
+	countRefs := func() (int, error) {
+		iter, err := repo.References()
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		err = iter.ForEach(func(ref *plumbing.Reference) error {
+			if strings.HasPrefix(ref.Name().String(), "refs/remotes/"+name+"/") && ref.Name() != plumbing.NewRemoteHEADReferenceName(name) {
+				count++
+			}
+			return nil
+		})
+		return count, err
+	}
+	
+	before, err := countRefs()
+	if err != nil {
+		return 0, err
+	}
+	if err := fetch(ctx, repo, name, gogit.FetchOptions{Prune: true}); err != nil {
+		return 0, err
+	}
+	after, err := countRefs()
+	if err != nil {
+		return 0, err
+	}
+	
+	return max(before-after, 0), nil
+}
+
+// ConflictFiles searches the checked out commit rather than the files on
+// disk, which hold the same content after a pull.
+func (GoGit) ConflictFiles(ctx context.Context, dir string) ([]string, error) {
+	repo, err := openRepository(dir)
+	if err != nil {
+		return nil, err
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	
+	found := make(map[string]int)
+	for _, marker := range conflictMarkers {
+		matches, err := wt.Grep(&gogit.GrepOptions{Patterns: []*regexp.Regexp{marker}})
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, match := range matches {
+			if !seen[match.FileName] {
+				seen[match.FileName] = true
+				found[match.FileName]++
+			}
+		}
+	}
+	
+	var files []string
+	for file, markers := range found {
+		if markers == len(conflictMarkers) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Maintenance is not supported: go-git can repack objects, but not while
+// keeping the ones only reachable from reflogs and the index, as git gc does.
+func (GoGit) Maintenance(ctx context.Context, dir string) error {
+	return unsupported("maintenance")
+}
+
+func (GoGit) Fsck(ctx context.Context, dir string) ([]string, error) {
+	return nil, unsupported("checking for corruption")
+}
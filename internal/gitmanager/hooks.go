@@ -0,0 +1,70 @@
+package gitmanager
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+)
+
+// RunHook runs command through the shell in dir. PULLIO_REPO and
+// PULLIO_BRANCH tell the command which repository and branch it runs for.
+func RunHook(ctx context.Context, dir, branch, command string) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+	
+	cmd := ExecCommand(ctx, shell, flag, command)
+	cmd.Dir = dir
+	cmd.Env = append(commandEnv(), "PULLIO_REPO="+dir, "PULLIO_BRANCH="+branch)
+	killProcessGroup(cmd)
+	
+	logger.FromContext(ctx).Debug("Running hook %s in %s", command, dir)
+	
+	output, err := cmd.CombinedOutput()
+	outputStr := strings.TrimSpace(string(output))
+	if outputStr != "" {
+		logger.FromContext(ctx).Debug("Hook output: %s", outputStr)
+	}
+	
+	if err != nil {
+		if outputStr != "" {
+			return fmt.Errorf("%v: %s", err, outputStr)
+		}
+		return err
+	}
+	
+	return nil
+}
+
+// HeadCommit returns the commit HEAD points at.
+func HeadCommit(ctx context.Context, dir string) (string, error) {
+	return runGitCommand(ctx, dir, "rev-parse", "HEAD")
+}
+
+// runHook runs one of the hooks from opts and reports whether processing
+// should go on. Failures fail the repository unless IgnoreHookErrors is set,
+// in which case they are only recorded.
+func runHook(ctx context.Context, repoPath, branch, name, command string, opts Options, result *RepoResult) bool {
+	log := logger.FromContext(ctx)
+	
+	err := RunHook(ctx, repoPath, branch, command)
+	if err == nil {
+		log.Debug("%s succeeded", name)
+		return true
+	}
+	
+	if opts.IgnoreHookErrors {
+		result.HookError = fmt.Sprintf("%s failed: %v", name, err)
+		log.Warning("%s failed: %v", name, err)
+		return true
+	}
+	
+	result.Err = fmt.Errorf("%w: %w", ErrHookFailed, err)
+	result.ErrorMessage = fmt.Sprintf("%s failed: %v", name, err)
+	log.Error("%s failed: %v", name, err)
+	return false
+}
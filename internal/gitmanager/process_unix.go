@@ -0,0 +1,20 @@
+//go:build !windows
+
+package gitmanager
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroup starts cmd in its own process group and makes
+// cancellation kill the whole group, so helpers such as ssh or
+// git-remote-https do not outlive a git command that timed out.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
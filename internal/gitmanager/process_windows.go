@@ -0,0 +1,14 @@
+//go:build windows
+
+package gitmanager
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroup bounds how long Wait blocks on output pipes that are
+// still held open by processes git started after it was killed.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = 5 * time.Second
+}
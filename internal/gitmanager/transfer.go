@@ -0,0 +1,72 @@
+package gitmanager
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+)
+
+// Transfer counts what a fetch or pull received from the remote.
+type Transfer struct {
+	Objects int
+	Bytes   int64
+}
+
+// statsArgs prefixes a fetch or pull so that it reports what it received
+// when stats are collected. Small transfers are normally unpacked into loose
+// objects, which only shows progress after a delay, so they are kept as a
+// pack instead.
+func statsArgs(opts Options, args ...string) []string {
+	if !opts.Stats {
+		return args
+	}
+	
+	return append([]string{"-c", "fetch.unpackLimit=1"}, args...)
+}
+
+// progressArgs returns the flag that makes git print its transfer progress
+// when stats are collected or debug output is shown, and keeps it quiet
+// otherwise.
+func progressArgs(opts Options) []string {
+	if opts.Stats || logger.Enabled(logger.LevelDebug) {
+		return []string{"--progress"}
+	}
+	
+	return []string{"-q"}
+}
+
+// transferPattern matches the final progress line of a transfer, such as
+// "Receiving objects: 100% (3/3), 293.30 KiB | 73.32 MiB/s, done." Small
+// transfers may not report a size.
+var transferPattern = regexp.MustCompile(`Receiving objects: 100% \((\d+)/\d+\)(?:, ([\d.]+) (bytes|KiB|MiB|GiB))?.*done\.`)
+
+var unitSizes = map[string]float64{
+	"bytes": 1,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+}
+
+// parseTransfer adds up the transfers reported in git's progress output, one
+// for each remote that was fetched from.
+func parseTransfer(output string) Transfer {
+	var t Transfer
+	// Progress updates are separated by carriage returns.
+	for _, line := range strings.FieldsFunc(output, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		m := transferPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		
+		objects, _ := strconv.Atoi(m[1])
+		t.Objects += objects
+		if m[2] != "" {
+			size, _ := strconv.ParseFloat(m[2], 64)
+			t.Bytes += int64(size * unitSizes[m[3]])
+		}
+	}
+	
+	return t
+}
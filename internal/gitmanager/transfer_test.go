@@ -0,0 +1,50 @@
+package gitmanager
+
+import "testing"
+
+func TestParseTransfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   Transfer
+	}{
+		{
+			name:   "bytes",
+			output: "Receiving objects: 100% (3/3), 512 bytes | 512.00 KiB/s, done.",
+			want:   Transfer{Objects: 3, Bytes: 512},
+		},
+		{
+			name:   "KiB",
+			output: "Receiving objects:  50% (2/4)\rReceiving objects: 100% (4/4), 1.50 KiB | 1.50 MiB/s, done.\n",
+			want:   Transfer{Objects: 4, Bytes: 1536},
+		},
+		{
+			name:   "MiB",
+			output: "remote: Enumerating objects: 120, done.\nReceiving objects: 100% (120/120), 2.00 MiB | 10.00 MiB/s, done.\nResolving deltas: 100% (40/40), done.",
+			want:   Transfer{Objects: 120, Bytes: 2 << 20},
+		},
+		{
+			name:   "no size",
+			output: "Receiving objects: 100% (1/1), done.",
+			want:   Transfer{Objects: 1},
+		},
+		{
+			name:   "two remotes",
+			output: "Receiving objects: 100% (2/2), done.\nReceiving objects: 100% (3/3), 1.00 KiB | 1.00 MiB/s, done.",
+			want:   Transfer{Objects: 5, Bytes: 1024},
+		},
+		{
+			name:   "nothing received",
+			output: "Already up to date.",
+			want:   Transfer{},
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTransfer(tt.output); got != tt.want {
+				t.Errorf("parseTransfer(%q) = %+v, want %+v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
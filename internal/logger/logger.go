@@ -1,12 +1,20 @@
 package logger
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"runtime"
 	"strings"
 	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
 )
 
 var (
@@ -16,7 +24,17 @@ var (
 	successLogger = log.New(os.Stdout, "", 0)
 	debugLogger   = log.New(os.Stdout, "", 0)
 	
-	verbose = false
+	level = LevelInfo
+	
+	// console receives everything except errors; logFile, when set, gets a
+	// copy of every line.
+	console io.Writer = os.Stdout
+	logFile io.Writer
+	
+	// outputMu serializes writes so a progress line drawn in place can be
+	// cleared before anything else is printed.
+	outputMu        sync.Mutex
+	progressVisible = false
 	
 	// ANSI color codes
 	useColors = true
@@ -35,10 +53,158 @@ func init() {
 	if runtime.GOOS == "windows" && os.Getenv("TERM") == "" && os.Getenv("WT_SESSION") == "" {
 		useColors = false
 	}
+	
+	// Any value of NO_COLOR turns colors off, see https://no-color.org.
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		useColors = false
+	}
+}
+
+// SetColors turns colored output on or off, overriding the default picked
+// from the platform and NO_COLOR.
+func SetColors(enabled bool) {
+	useColors = enabled
+}
+
+// Level is the minimum severity of messages that are printed.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	// LevelSilent suppresses everything except fatal errors.
+	LevelSilent
+)
+
+// ParseLevel converts a level name such as "warn" into a Level.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	case "silent":
+		return LevelSilent, nil
+	}
+	
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn, error or silent", name)
+}
+
+func SetLevel(l Level) {
+	level = l
+}
+
+// Enabled reports whether messages of level l are printed.
+func Enabled(l Level) bool {
+	return level <= l
 }
 
+// SetVerbose enables debug output. It is kept for callers that predate
+// SetLevel.
 func SetVerbose(v bool) {
-	verbose = v
+	if v {
+		level = LevelDebug
+	} else {
+		level = LevelInfo
+	}
+}
+
+// SetOutput redirects everything except errors, which always go to stderr.
+func SetOutput(w io.Writer) {
+	console = w
+	applyOutputs()
+}
+
+// ConsoleIsTerminal reports whether the output set with SetOutput is a
+// terminal rather than a pipe or a file.
+func ConsoleIsTerminal() bool {
+	return isTerminal(console)
+}
+
+// SetLogFile copies every printed line to w, timestamped and without colors.
+func SetLogFile(w io.Writer) {
+	logFile = &fileWriter{w: w}
+	applyOutputs()
+}
+
+// LogFile returns the writer set with SetLogFile, or nil if there is none.
+func LogFile() io.Writer {
+	return logFile
+}
+
+func applyOutputs() {
+	out := console
+	errOut := io.Writer(os.Stderr)
+	if logFile != nil {
+		out = io.MultiWriter(console, logFile)
+		errOut = io.MultiWriter(os.Stderr, logFile)
+	}
+	
+	infoLogger.SetOutput(out)
+	warningLogger.SetOutput(out)
+	successLogger.SetOutput(out)
+	debugLogger.SetOutput(out)
+	errorLogger.SetOutput(errOut)
+}
+
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// fileWriter strips ANSI color codes and prefixes each line with a
+// timestamp. Writes are serialized since it is shared by all loggers.
+type fileWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (f *fileWriter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	
+	timestamp := time.Now().Format(time.RFC3339)
+	clean := ansiPattern.ReplaceAllString(string(p), "")
+	
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(clean, "\n") {
+		if strings.TrimSpace(line) != "" {
+			b.WriteString(timestamp + " ")
+		}
+		b.WriteString(line)
+	}
+	
+	if _, err := io.WriteString(f.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// output prints message on l, clearing the progress line first if needed.
+func output(l *log.Logger, message string) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	
+	clearProgress()
+	l.Println(message)
+}
+
+// clearProgress erases the progress line if one is drawn. The caller must
+// hold outputMu.
+func clearProgress() {
+	if progressVisible {
+		fmt.Fprint(console, "\r\033[K")
+		progressVisible = false
+	}
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && term.IsTerminal(int(f.Fd()))
 }
 
 func colored(color, format string, args ...interface{}) string {
@@ -51,42 +217,226 @@ func colored(color, format string, args ...interface{}) string {
 	return message
 }
 
+// Green, Yellow, Red and Cyan color s the way log messages of the matching
+// level are, unless colors are disabled.
+func Green(s string) string  { return colored(green, "%s", s) }
+func Yellow(s string) string { return colored(yellow, "%s", s) }
+func Red(s string) string    { return colored(red, "%s", s) }
+func Cyan(s string) string   { return colored(cyan, "%s", s) }
+
 func Info(format string, args ...interface{}) {
-	message := colored(blue, "ℹ️ "+format, args...)
-	infoLogger.Println(message)
+	(*Buffer)(nil).Info(format, args...)
 }
 
 func Warning(format string, args ...interface{}) {
-	message := colored(yellow, "⚠️ "+format, args...)
-	warningLogger.Println(message)
+	(*Buffer)(nil).Warning(format, args...)
 }
 
 func Error(format string, args ...interface{}) {
-	message := colored(red, "❌ "+format, args...)
-	errorLogger.Println(message)
+	(*Buffer)(nil).Error(format, args...)
 }
 
 func Success(format string, args ...interface{}) {
-	message := colored(green, "✅ "+format, args...)
-	successLogger.Println(message)
+	(*Buffer)(nil).Success(format, args...)
 }
 
 func Debug(format string, args ...interface{}) {
-	if !verbose {
+	(*Buffer)(nil).Debug(format, args...)
+}
+
+// Buffer collects the lines logged for one repository so they can be printed
+// as a single block once it is done, instead of interleaving with the output
+// of repositories processed in parallel. A nil *Buffer prints immediately.
+type Buffer struct {
+	mu      sync.Mutex
+	entries []entry
+	// header is set by RepoHeader and logged in front of the first line
+	// that is, so it shows even when only warnings or errors are printed.
+	header  string
+	observe func(kind, message string)
+}
+
+type entry struct {
+	l       *log.Logger
+	message string
+}
+
+func NewBuffer() *Buffer {
+	return &Buffer{}
+}
+
+type bufferKey struct{}
+
+// WithBuffer returns a copy of ctx that carries b.
+func WithBuffer(ctx context.Context, b *Buffer) context.Context {
+	return context.WithValue(ctx, bufferKey{}, b)
+}
+
+// FromContext returns the Buffer carried by ctx, or nil if there is none so
+// that lines are printed immediately.
+func FromContext(ctx context.Context) *Buffer {
+	b, _ := ctx.Value(bufferKey{}).(*Buffer)
+	return b
+}
+
+func (b *Buffer) add(l *log.Logger, message string) {
+	if b == nil {
+		output(l, message)
+		return
+	}
+	
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.header != "" {
+		b.entries = append(b.entries, entry{l: l, message: b.header})
+		b.header = ""
+	}
+	b.entries = append(b.entries, entry{l: l, message: message})
+}
+
+// Observe makes b also pass every line it logs to fn as it is logged, without
+// colors, along with its kind: info, warning, error, success or debug.
+func (b *Buffer) Observe(fn func(kind, message string)) {
+	b.observe = fn
+}
+
+func (b *Buffer) notify(kind, format string, args []interface{}) {
+	if b == nil || b.observe == nil {
+		return
+	}
+	
+	b.observe(kind, fmt.Sprintf(format, args...))
+}
+
+// Flush prints the buffered lines in one go and empties the buffer.
+func (b *Buffer) Flush() {
+	if b == nil {
+		return
+	}
+	
+	b.mu.Lock()
+	entries := b.entries
+	b.entries = nil
+	b.mu.Unlock()
+	
+	if len(entries) == 0 {
+		return
+	}
+	
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	
+	clearProgress()
+	for _, e := range entries {
+		e.l.Println(e.message)
+	}
+}
+
+func (b *Buffer) Info(format string, args ...interface{}) {
+	if level > LevelInfo {
+		return
+	}
+	
+	b.notify("info", format, args)
+	message := colored(blue, "ℹ️ "+format, args...)
+	b.add(infoLogger, message)
+}
+
+func (b *Buffer) Warning(format string, args ...interface{}) {
+	if level > LevelWarn {
+		return
+	}
+	
+	b.notify("warning", format, args)
+	message := colored(yellow, "⚠️ "+format, args...)
+	b.add(warningLogger, message)
+}
+
+func (b *Buffer) Error(format string, args ...interface{}) {
+	if level > LevelError {
+		return
+	}
+	
+	b.notify("error", format, args)
+	message := colored(red, "❌ "+format, args...)
+	b.add(errorLogger, message)
+}
+
+func (b *Buffer) Success(format string, args ...interface{}) {
+	if level > LevelInfo {
+		return
+	}
+	
+	b.notify("success", format, args)
+	message := colored(green, "✅ "+format, args...)
+	b.add(successLogger, message)
+}
+
+func (b *Buffer) Debug(format string, args ...interface{}) {
+	if level > LevelDebug {
 		return
 	}
 	
+	b.notify("debug", format, args)
 	message := colored(magenta, "🔍 "+format, args...)
-	debugLogger.Println(message)
+	b.add(debugLogger, message)
+}
+
+// LiveWriter returns a writer that prints what a command writes to it, such
+// as git's transfer progress, as debug lines prefixed with label. Unlike a
+// Buffer it prints right away, so long transfers can be followed while they
+// run. Lines that are redrawn in place, ending in a carriage return, are
+// printed at most once a second.
+func LiveWriter(label string) io.Writer {
+	return &liveWriter{label: label}
+}
+
+type liveWriter struct {
+	label   string
+	pending []byte
+	last    time.Time
+}
+
+func (w *liveWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		end := bytes.IndexAny(w.pending, "\r\n")
+		if end < 0 {
+			return len(p), nil
+		}
+		line := strings.TrimSpace(string(w.pending[:end]))
+		redrawn := w.pending[end] == '\r'
+		w.pending = w.pending[end+1:]
+		
+		if line == "" || (redrawn && time.Since(w.last) < time.Second) {
+			continue
+		}
+		w.last = time.Now()
+		Debug("%s: %s", w.label, line)
+	}
 }
 
+// FatalExitCode is the status Fatal exits with. It differs from the status
+// used for failed repositories so scripts can tell setup errors apart.
+const FatalExitCode = 2
+
 func Fatal(format string, args ...interface{}) {
 	message := colored(red, "💥 FATAL: "+format, args...)
-	errorLogger.Println(message)
-	os.Exit(1)
+	output(errorLogger, message)
+	os.Exit(FatalExitCode)
+}
+
+func RepoHeader(repoPath, name string) {
+	(*Buffer)(nil).RepoHeader(repoPath, name)
 }
 
-func RepoHeader(repoPath string) {
+// RepoHeader starts the output of the repository at repoPath, shown
+// relative to the working directory when it is below it. A name, such as
+// owner/repo, is shown in front of the path. A Buffer holds the header back
+// until a line is logged, so a repository is named above its warnings and
+// errors even when info lines are not shown, and not at all when nothing is
+// printed for it.
+func (b *Buffer) RepoHeader(repoPath, name string) {
 	displayPath := repoPath
 	cwd, err := os.Getwd()
 	if err == nil {
@@ -95,7 +445,43 @@ func RepoHeader(repoPath string) {
 		}
 	}
 	
-	fmt.Println()
 	message := colored(cyan, "📁 %s", displayPath)
-	infoLogger.Println(message)
+	if name != "" {
+		message = colored(cyan, "📁 %s (%s)", name, displayPath)
+	}
+	
+	if b == nil {
+		if level <= LevelInfo {
+			output(infoLogger, "\n"+message)
+		}
+		return
+	}
+	b.mu.Lock()
+	b.header = "\n" + message
+	b.mu.Unlock()
+}
+
+// Progress reports that done out of total repositories have been processed.
+// On a terminal the line is redrawn in place, otherwise a plain line is
+// printed for each call.
+func Progress(done, total int) {
+	if level > LevelInfo {
+		return
+	}
+	
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	
+	// The progress line is only shown on the console, never in the log file.
+	w := console
+	if !isTerminal(w) {
+		fmt.Fprintf(w, "[%d/%d]\n", done, total)
+		return
+	}
+	
+	fmt.Fprintf(w, "\r\033[K%s", colored(cyan, "[%d/%d]", done, total))
+	progressVisible = done < total
+	if !progressVisible {
+		fmt.Fprintln(w)
+	}
 }
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
+	"github.com/lyubomir-bozhinov/pullio/internal/report"
+)
+
+// Format renders the outcome of a run that took duration and ended at end in
+// the Prometheus text exposition format.
+func Format(s report.Summary, duration time.Duration, end time.Time) string {
+	g := s.Group()
+	
+	var b strings.Builder
+	gauge := func(name, help string, value any) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("pullio_repos_total", "Repositories found by the last run.", len(s.Results))
+	gauge("pullio_repos_succeeded", "Repositories the last run updated or found up to date.", len(g.Succeeded))
+	gauge("pullio_repos_skipped", "Repositories the last run skipped.", len(g.Skipped))
+	gauge("pullio_repos_failed", "Repositories the last run failed to update.", len(g.Failed))
+	gauge("pullio_repos_cancelled", "Repositories the last run did not finish because it was interrupted.", len(g.Cancelled))
+	gauge("pullio_run_duration_seconds", "How long the last run took.", duration.Seconds())
+	gauge("pullio_last_run_timestamp_seconds", "When the last run ended, in seconds since the Unix epoch.", end.Unix())
+	
+	failures := make(map[gitmanager.Reason]int)
+	for _, r := range g.Failed {
+		failures[r.Reason]++
+	}
+	reasons := make([]string, 0, len(failures))
+	for reason := range failures {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	
+	b.WriteString("# HELP pullio_repos_failed_by_reason Repositories the last run failed to update, by reason.\n")
+	b.WriteString("# TYPE pullio_repos_failed_by_reason gauge\n")
+	for _, reason := range reasons {
+		label := reason
+		if label == "" {
+			label = "other"
+		}
+		fmt.Fprintf(&b, "pullio_repos_failed_by_reason{reason=%q} %d\n", label, failures[gitmanager.Reason(reason)])
+	}
+	
+	return b.String()
+}
+
+// WriteFile writes the metrics of a run to path for the node_exporter
+// textfile collector. The file is written next to path first and renamed
+// into place, so the collector never reads a partial file.
+func WriteFile(path string, s report.Summary, duration time.Duration, end time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	
+	if _, err := tmp.WriteString(Format(s, duration, end)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	
+	// CreateTemp makes the file readable by its owner only, but the
+	// collector may run as another user.
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+var ExecCommand = exec.Command
+
+// Send shows a desktop notification using the tools that ship with each
+// platform: notify-send on Linux, osascript on macOS and a PowerShell toast
+// on Windows.
+func Send(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = ExecCommand("osascript", "-e", script)
+	case "windows":
+		cmd = ExecCommand("powershell", "-NoProfile", "-Command", toastScript(title, message))
+	default:
+		cmd = ExecCommand("notify-send", title, message)
+	}
+	
+	output, err := cmd.CombinedOutput()
+	if outputStr := strings.TrimSpace(string(output)); err != nil && outputStr != "" {
+		return fmt.Errorf("%v: %s", err, outputStr)
+	}
+	return err
+}
+
+// appleScriptString quotes s as an AppleScript string literal.
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// powerShellString quotes s as a single-quoted PowerShell string literal.
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// toastScript builds a PowerShell script that shows a toast notification
+// through the Windows Runtime API.
+func toastScript(title, message string) string {
+	return strings.Join([]string{
+		"[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null",
+		"$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)",
+		"$text = $template.GetElementsByTagName('text')",
+		fmt.Sprintf("$text.Item(0).AppendChild($template.CreateTextNode(%s)) > $null", powerShellString(title)),
+		fmt.Sprintf("$text.Item(1).AppendChild($template.CreateTextNode(%s)) > $null", powerShellString(message)),
+		"[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('pullio').Show([Windows.UI.Notifications.ToastNotification]::new($template))",
+	}, "; ")
+}
@@ -0,0 +1,610 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+)
+
+// Summary holds the results of a run along with the settings needed to
+// describe them.
+type Summary struct {
+	Results   []gitmanager.RepoResult
+	DryRun    bool
+	FetchOnly bool
+	// ReportAhead is set when the run only looked for unpushed work, and
+	// ReportFsck when it only checked for corruption.
+	ReportAhead bool
+	ReportFsck  bool
+}
+
+// Groups holds the results of a run split by outcome.
+type Groups struct {
+	Succeeded []gitmanager.RepoResult
+	Skipped   []gitmanager.RepoResult
+	Failed    []gitmanager.RepoResult
+	// Filtered holds repositories left out by the remote filter.
+	Filtered []gitmanager.RepoResult
+	// Cancelled holds repositories that were not finished because the run
+	// was interrupted.
+	Cancelled []gitmanager.RepoResult
+}
+
+// Group splits the results by outcome.
+func (s Summary) Group() Groups {
+	var g Groups
+	for _, r := range s.Results {
+		if r.Filtered {
+			g.Filtered = append(g.Filtered, r)
+		} else if r.Cancelled {
+			g.Cancelled = append(g.Cancelled, r)
+		} else if r.Success {
+			g.Succeeded = append(g.Succeeded, r)
+		} else if r.Skipped {
+			g.Skipped = append(g.Skipped, r)
+		} else {
+			g.Failed = append(g.Failed, r)
+		}
+	}
+	
+	return g
+}
+
+// formatDuration rounds d to a precision that is readable in the summary.
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	
+	return d.Round(100 * time.Millisecond).String()
+}
+
+// formatBytes prints n in the largest binary unit that keeps it above one.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	
+	value, suffix := float64(n)/unit, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// Headline sums up the run in one line, such as "Done. 3 updated, 1 already
+// current, 0 skipped, 1 failed".
+func Headline(s Summary) string {
+	g := s.Group()
+	succeeded, skipped, failed := g.Succeeded, g.Skipped, g.Failed
+	
+	// Repositories left out by the remote filter are only counted.
+	notes := ""
+	if len(g.Filtered) > 0 {
+		notes += fmt.Sprintf(", %d filtered out", len(g.Filtered))
+	}
+	if len(g.Cancelled) > 0 {
+		notes += fmt.Sprintf(", %d cancelled", len(g.Cancelled))
+	}
+	
+	var stale int
+	for _, r := range skipped {
+		if r.Stale {
+			stale++
+		}
+	}
+	skippedCount := fmt.Sprintf("%d skipped", len(skipped))
+	if stale > 0 {
+		skippedCount = fmt.Sprintf("%d skipped (%d stale)", len(skipped), stale)
+	}
+	
+	if s.ReportFsck {
+		var corrupt int
+		for _, r := range succeeded {
+			if r.Corrupt {
+				corrupt++
+			}
+		}
+		return fmt.Sprintf("Done. %d corrupt, %d healthy, %s, %d failed%s", corrupt, len(succeeded)-corrupt, skippedCount, len(failed), notes)
+	}
+	
+	if s.ReportAhead {
+		var unpushed int
+		for _, r := range succeeded {
+			if hasUnpushedWork(r) {
+				unpushed++
+			}
+		}
+		return fmt.Sprintf("Done. %d with unpushed work, %d fully pushed, %s, %d failed%s", unpushed, len(succeeded)-unpushed, skippedCount, len(failed), notes)
+	}
+	
+	action := "updated"
+	if s.FetchOnly {
+		action = "fetched"
+	}
+	
+	if s.DryRun {
+		return fmt.Sprintf("Dry run. %d would be %s, %s, %d failed%s", len(succeeded), action, skippedCount, len(failed), notes)
+	}
+	
+	// Pulls that brought in nothing are counted apart from real updates.
+	updated := fmt.Sprintf("%d %s", len(succeeded), action)
+	if !s.FetchOnly {
+		var current int
+		for _, r := range succeeded {
+			if !r.Changed {
+				current++
+			}
+		}
+		updated = fmt.Sprintf("%d %s, %d already current", len(succeeded)-current, action, current)
+	}
+	
+	return fmt.Sprintf("Done. %s, %s, %d failed%s", updated, skippedCount, len(failed), notes)
+}
+
+// WriteText prints the human-readable summary.
+func WriteText(w io.Writer, s Summary) {
+	g := s.Group()
+	succeeded, skipped, failed := g.Succeeded, g.Skipped, g.Failed
+	
+	// Stale repositories are skipped on purpose and only counted.
+	var listed []gitmanager.RepoResult
+	for _, r := range skipped {
+		if !r.Stale {
+			listed = append(listed, r)
+		}
+	}
+	
+	action := "updated"
+	if s.FetchOnly {
+		action = "fetched"
+	}
+	
+	fmt.Fprintf(w, "\n📦 %s.\n", Headline(s))
+	
+	var objects int
+	var bytes int64
+	for _, r := range s.Results {
+		objects += r.ObjectsReceived
+		bytes += r.BytesReceived
+	}
+	if objects > 0 {
+		fmt.Fprintf(w, "📥 Received %d objects, %s in total.\n", objects, formatBytes(bytes))
+	}
+	
+	if s.ReportFsck {
+		writeHealth(w, succeeded)
+	} else if s.ReportAhead {
+		writeUnpushed(w, succeeded)
+	} else if len(succeeded) > 0 {
+		if s.DryRun {
+			fmt.Fprintf(w, "\nRepositories that would be %s:\n", action)
+		} else {
+			fmt.Fprintf(w, "\nSuccessfully %s repositories:\n", action)
+		}
+		for _, r := range succeeded {
+			icon := "✅"
+			if r.DryRun {
+				icon = "🔎"
+			}
+			fmt.Fprintf(w, "%s %s (%s)\n", icon, displayName(r), strings.Join(details(r), ", "))
+			writeBranches(w, r.Branches)
+		}
+	}
+	
+	if len(listed) > 0 {
+		fmt.Fprintln(w, "\nSkipped repositories:")
+		for _, r := range listed {
+			reason := r.ErrorMessage
+			if r.Behind > 0 {
+				reason += fmt.Sprintf(", behind %d", r.Behind)
+			}
+			fmt.Fprintf(w, "⏭️ %s (reason: %s, %s)\n", displayName(r), reason, formatDuration(r.Duration))
+		}
+	}
+	
+	if len(failed) > 0 {
+		// Failures that share a reason usually share a cause too, such as a
+		// VPN that is down, so they are listed together, largest group first.
+		fmt.Fprintln(w, "\nFailed repositories:")
+		for _, group := range groupByReason(failed) {
+			fmt.Fprintf(w, "%s (%d):\n", group.reason, len(group.results))
+			for _, r := range group.results {
+				fmt.Fprintf(w, "❌ %s (reason: %s, %s)\n", displayName(r), r.ErrorMessage, formatDuration(r.Duration))
+			}
+		}
+		
+		// Several repositories failing to authenticate usually share one
+		// cause, such as a key that is not loaded.
+		var authFailures int
+		for _, r := range failed {
+			if r.Reason == gitmanager.ReasonAuthFailed {
+				authFailures++
+			}
+		}
+		if authFailures > 1 {
+			fmt.Fprintf(w, "\n💡 %d repositories failed to authenticate. Check that the right keys are loaded with `ssh-add -l`, or the credential helper for HTTPS remotes.\n", authFailures)
+		}
+	}
+	
+	if len(g.Cancelled) > 0 {
+		fmt.Fprintln(w, "\nCancelled repositories:")
+		for _, r := range g.Cancelled {
+			fmt.Fprintf(w, "🛑 %s\n", displayName(r))
+		}
+	}
+}
+
+// reasonGroup holds the results that share a reason.
+type reasonGroup struct {
+	reason  gitmanager.Reason
+	results []gitmanager.RepoResult
+}
+
+// groupByReason groups results by reason, the largest group first and groups
+// of the same size by name. Results without a reason are grouped as "other".
+func groupByReason(results []gitmanager.RepoResult) []reasonGroup {
+	var groups []reasonGroup
+	index := make(map[gitmanager.Reason]int)
+	for _, r := range results {
+		reason := r.Reason
+		if reason == "" {
+			reason = "other"
+		}
+		i, ok := index[reason]
+		if !ok {
+			i = len(groups)
+			index[reason] = i
+			groups = append(groups, reasonGroup{reason: reason})
+		}
+		groups[i].results = append(groups[i].results, r)
+	}
+	
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].results) != len(groups[j].results) {
+			return len(groups[i].results) > len(groups[j].results)
+		}
+		return groups[i].reason < groups[j].reason
+	})
+	return groups
+}
+
+// displayName returns how a repository is shown in the summary: by the
+// name from its remote when NameFromRemote set one, by its path otherwise.
+func displayName(r gitmanager.RepoResult) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Path
+}
+
+// details describes a successful repository for the summary.
+func details(r gitmanager.RepoResult) []string {
+	var parts []string
+	if r.Fetched {
+		parts = append(parts, "fetched")
+	}
+	if r.Branch != "" {
+		parts = append(parts, "branch: "+r.Branch)
+	}
+	if !r.Changed && !r.Fetched && !r.DryRun {
+		parts = append(parts, "already up to date")
+	}
+	if r.Ahead > 0 {
+		parts = append(parts, fmt.Sprintf("ahead %d", r.Ahead))
+	}
+	if r.Behind > 0 {
+		parts = append(parts, fmt.Sprintf("behind %d", r.Behind))
+	}
+	if r.NewTags > 0 {
+		parts = append(parts, fmt.Sprintf("%d new tags", r.NewTags))
+	}
+	if len(r.MovedTags) > 0 {
+		parts = append(parts, fmt.Sprintf("moved tags: %s", strings.Join(r.MovedTags, " ")))
+	}
+	if len(r.ChangedFiles) > 0 {
+		parts = append(parts, fmt.Sprintf("left modified: %s", strings.Join(r.ChangedFiles, " ")))
+	}
+	if len(r.ConflictFiles) > 0 {
+		parts = append(parts, fmt.Sprintf("conflict markers: %s", strings.Join(r.ConflictFiles, " ")))
+	}
+	if r.Unshallowed {
+		parts = append(parts, "unshallowed in "+formatDuration(r.UnshallowDuration))
+	} else if r.Shallow {
+		parts = append(parts, "shallow")
+	}
+	if r.Reset && r.Discarded > 0 {
+		parts = append(parts, fmt.Sprintf("reset, discarded %d local commits", r.Discarded))
+	} else if r.Reset {
+		parts = append(parts, "reset")
+	}
+	if r.Pruned > 0 {
+		parts = append(parts, fmt.Sprintf("pruned %d", r.Pruned))
+	}
+	if r.GC {
+		parts = append(parts, "gc in "+formatDuration(r.GCDuration))
+	}
+	if r.OpenRequests != nil && *r.OpenRequests > 0 {
+		parts = append(parts, fmt.Sprintf("%d open PRs", *r.OpenRequests))
+	}
+	if r.HookError != "" {
+		parts = append(parts, r.HookError)
+	}
+	
+	return append(parts, formatDuration(r.Duration))
+}
+
+// writeBranches lists the other branches that were fast-forwarded or could
+// not be, below their repository.
+func writeBranches(w io.Writer, branches []gitmanager.BranchResult) {
+	for _, b := range branches {
+		if b.Success {
+			fmt.Fprintf(w, "   ↳ %s fast-forwarded\n", b.Name)
+		} else {
+			fmt.Fprintf(w, "   ↳ %s not updated (reason: %s)\n", b.Name, b.ErrorMessage)
+		}
+	}
+}
+
+// hasUnpushedWork reports whether r has commits or changes that exist only
+// in the local clone.
+func hasUnpushedWork(r gitmanager.RepoResult) bool {
+	return len(r.Unpushed) > 0 || r.Uncommitted
+}
+
+// writeUnpushed lists the repositories with work that would be lost along
+// with the clone, and under each one what it is.
+func writeUnpushed(w io.Writer, results []gitmanager.RepoResult) {
+	var listed []gitmanager.RepoResult
+	for _, r := range results {
+		if hasUnpushedWork(r) {
+			listed = append(listed, r)
+		}
+	}
+	if len(listed) == 0 {
+		return
+	}
+	
+	fmt.Fprintln(w, "\nRepositories with unpushed work:")
+	for _, r := range listed {
+		fmt.Fprintf(w, "⚠️ %s\n", displayName(r))
+		for _, b := range r.Unpushed {
+			if b.Upstream == "" {
+				fmt.Fprintf(w, "   ↳ %s: %d commits on no remote\n", b.Name, b.Commits)
+			} else {
+				fmt.Fprintf(w, "   ↳ %s: %d commits not pushed to %s\n", b.Name, b.Commits, strings.TrimPrefix(b.Upstream, "refs/remotes/"))
+			}
+		}
+		if r.Uncommitted {
+			fmt.Fprintln(w, "   ↳ uncommitted changes")
+		}
+	}
+}
+
+// writeHealth lists the corrupt repositories, with the problems found in
+// each, apart from the healthy ones.
+func writeHealth(w io.Writer, results []gitmanager.RepoResult) {
+	var corrupt, healthy []gitmanager.RepoResult
+	for _, r := range results {
+		if r.Corrupt {
+			corrupt = append(corrupt, r)
+		} else {
+			healthy = append(healthy, r)
+		}
+	}
+	
+	if len(corrupt) > 0 {
+		fmt.Fprintln(w, "\nCorrupt repositories:")
+		for _, r := range corrupt {
+			fmt.Fprintf(w, "💔 %s\n", displayName(r))
+			for _, problem := range r.FsckErrors {
+				fmt.Fprintf(w, "   ↳ %s\n", problem)
+			}
+		}
+	}
+	
+	if len(healthy) > 0 {
+		fmt.Fprintln(w, "\nHealthy repositories:")
+		for _, r := range healthy {
+			fmt.Fprintf(w, "✅ %s (%s)\n", displayName(r), formatDuration(r.Duration))
+		}
+	}
+}
+
+// maxPathWidth is the widest a path is shown in the table before it is cut.
+const maxPathWidth = 50
+
+// WriteTable prints the headline and one row per repository with its path,
+// branch, status and duration in aligned columns.
+func WriteTable(w io.Writer, s Summary) {
+	fmt.Fprintf(w, "\n📦 %s.\n\n", Headline(s))
+	
+	rows := [][]string{{"PATH", "BRANCH", "STATUS", "DURATION"}}
+	colors := []func(string) string{nil}
+	for _, r := range s.Results {
+		if r.Filtered {
+			continue
+		}
+		
+		branch := r.Branch
+		if branch == "" {
+			branch = "-"
+		}
+		status, color := tableStatus(r, s)
+		rows = append(rows, []string{truncatePath(displayName(r), maxPathWidth), branch, status, formatDuration(r.Duration)})
+		colors = append(colors, color)
+	}
+	
+	// Widths are counted on the plain text since the color codes take no
+	// space on the screen, which is also why text/tabwriter cannot be used.
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+	
+	const statusColumn = 2
+	for i, row := range rows {
+		var line strings.Builder
+		for j, cell := range row {
+			if j == len(row)-1 {
+				line.WriteString(cell)
+				break
+			}
+			
+			padding := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell)+2)
+			if j == statusColumn && colors[i] != nil {
+				cell = colors[i](cell)
+			}
+			line.WriteString(cell + padding)
+		}
+		fmt.Fprintln(w, line.String())
+	}
+}
+
+// tableStatus describes the outcome of r in a few words for the table,
+// along with the reason for skipped and failed repositories, and returns the
+// color to show it in. s is the summary r is part of.
+func tableStatus(r gitmanager.RepoResult, s Summary) (string, func(string) string) {
+	status, color := resultStatus(r, s)
+	switch {
+	case r.Cancelled || r.Success:
+		return status, color
+	case r.Stale:
+		return status + " (stale)", color
+	case r.Skipped && r.Behind > 0:
+		return fmt.Sprintf("%s (%s), behind %d", status, r.Reason, r.Behind), color
+	case r.Reason != "":
+		return fmt.Sprintf("%s (%s)", status, r.Reason), color
+	}
+	
+	return status, color
+}
+
+// resultStatus describes the outcome of r in a few words and returns the
+// color to show it in. s is the summary r is part of.
+func resultStatus(r gitmanager.RepoResult, s Summary) (string, func(string) string) {
+	switch {
+	case r.Cancelled:
+		return "cancelled", logger.Yellow
+	case r.Success && s.ReportFsck && r.Corrupt:
+		return "corrupt", logger.Red
+	case r.Success && s.ReportFsck:
+		return "healthy", logger.Green
+	case r.Success && s.ReportAhead && hasUnpushedWork(r):
+		return "unpushed work", logger.Yellow
+	case r.Success && s.ReportAhead:
+		return "pushed", logger.Green
+	case r.Success && r.DryRun && r.Fetched:
+		return "would fetch", logger.Cyan
+	case r.Success && r.DryRun:
+		return "would update", logger.Cyan
+	case r.Success && r.Fetched:
+		return "fetched", logger.Green
+	case r.Success && r.Changed:
+		return "updated", logger.Green
+	case r.Success:
+		return "up to date", logger.Green
+	case r.Skipped:
+		return "skipped", logger.Yellow
+	}
+	
+	return "failed", logger.Red
+}
+
+// truncatePath cuts path from the left to at most width characters, so the
+// repository name at the end stays visible.
+func truncatePath(path string, width int) string {
+	runes := []rune(path)
+	if len(runes) <= width {
+		return path
+	}
+	
+	return "…" + string(runes[len(runes)-width+1:])
+}
+
+// WriteJSON prints the results as a JSON array.
+func WriteJSON(w io.Writer, s Summary) error {
+	results := s.Results
+	if results == nil {
+		results = []gitmanager.RepoResult{}
+	}
+	
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("failed to encode results: %w", err)
+	}
+	
+	return nil
+}
+
+// ParseTemplate parses text as a text/template for WriteTemplate. The
+// template is also run once on an empty result, so fields that RepoResult
+// does not have are reported now rather than after the run.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("template").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, gitmanager.RepoResult{}); err != nil {
+		return nil, err
+	}
+	
+	return tmpl, nil
+}
+
+// WriteTemplate prints one line per repository by executing tmpl on its
+// gitmanager.RepoResult.
+func WriteTemplate(w io.Writer, s Summary, tmpl *template.Template) error {
+	for _, r := range s.Results {
+		if r.Filtered {
+			continue
+		}
+		
+		var line strings.Builder
+		if err := tmpl.Execute(&line, r); err != nil {
+			return fmt.Errorf("failed to execute template for %s: %w", r.Path, err)
+		}
+		fmt.Fprintln(w, strings.TrimSuffix(line.String(), "\n"))
+	}
+	
+	return nil
+}
+
+// WriteCSV prints one row per repository with its path, branch, status, the
+// reason it was skipped or failed and its duration in milliseconds, below a
+// header row.
+func WriteCSV(w io.Writer, s Summary) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"path", "branch", "status", "reason", "duration_ms"})
+	for _, r := range s.Results {
+		if r.Filtered {
+			continue
+		}
+		
+		status, _ := resultStatus(r, s)
+		out.Write([]string{r.Path, r.Branch, status, r.ErrorMessage, strconv.FormatInt(r.Duration.Milliseconds(), 10)})
+	}
+	
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+)
+
+// Job is a repository to process along with the host of its remote.
+type Job struct {
+	Path string
+	// Host is the host name of the remote, or empty when the remote is a
+	// local path. Jobs without a host are not limited per host.
+	Host string
+}
+
+// Run calls fn for each job, with at most limit calls running at the same
+// time and at most perHost of them for the same host. Jobs are started in
+// order as far as the limits allow, so a busy host does not hold up jobs
+// for other hosts. A perHost below 1 means no limit per host.
+//
+// Run returns once every started job has finished. When ctx is cancelled
+// no more jobs are started and the ones left are returned.
+func Run(ctx context.Context, jobs []Job, limit, perHost int, fn func(Job)) (notStarted []Job) {
+	if limit < 1 {
+		limit = 1
+	}
+	
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	
+	// Finished jobs report their host so its slot can be given away.
+	done := make(chan string, len(jobs))
+	running := 0
+	perHostRunning := make(map[string]int)
+	
+	pending := jobs
+	for len(pending) > 0 {
+		var waiting []Job
+		for _, job := range pending {
+			if running >= limit || (perHost > 0 && job.Host != "" && perHostRunning[job.Host] >= perHost) {
+				waiting = append(waiting, job)
+				continue
+			}
+			
+			running++
+			perHostRunning[job.Host]++
+			wg.Add(1)
+			go func(job Job) {
+				defer wg.Done()
+				fn(job)
+				done <- job.Host
+			}(job)
+		}
+		pending = waiting
+		if len(pending) == 0 {
+			break
+		}
+		
+		select {
+		case host := <-done:
+			running--
+			perHostRunning[host]--
+		case <-ctx.Done():
+			return pending
+		}
+		
+		// Stop starting jobs once cancelled even if one finished at the
+		// same time.
+		if ctx.Err() != nil {
+			return pending
+		}
+	}
+	
+	return nil
+}
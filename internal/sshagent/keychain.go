@@ -0,0 +1,53 @@
+package sshagent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+)
+
+// keychainSupported reports whether keys can be unlocked from the system
+// keychain on this platform.
+func keychainSupported() bool {
+	switch runtime.GOOS {
+	case "darwin":
+		return true
+	case "linux", "freebsd", "openbsd", "netbsd":
+		_, err := exec.LookPath("secret-tool")
+		return err == nil
+	}
+	
+	return false
+}
+
+// addSSHKeyFromKeychain runs ssh-add with the macOS keychain, which supplies
+// the passphrase or stores it after asking once.
+func addSSHKeyFromKeychain(sshKeyPath string) error {
+	cmd := ExecCommand("ssh-add", "--apple-use-keychain", sshKeyPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ssh-add command failed: %w", err)
+	}
+	
+	return nil
+}
+
+// keyringPassphrase looks up the passphrase of the key in the Secret Service
+// keyring, under the attribute GNOME Keyring uses for SSH keys. It returns an
+// empty string when there is none.
+func keyringPassphrase(sshKeyPath string) string {
+	output, err := ExecCommand("secret-tool", "lookup", "unique", "ssh-store:"+sshKeyPath).Output()
+	if err != nil {
+		logger.Debug("No passphrase for %s in the keyring: %v", sshKeyPath, err)
+		return ""
+	}
+	
+	return strings.TrimRight(string(output), "\r\n")
+}
@@ -1,8 +1,13 @@
 package sshagent
 
 import (
+	"crypto/dsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -12,28 +17,178 @@ import (
 	"time"
 
 	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+	"github.com/lyubomir-bozhinov/pullio/internal/utils"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
 )
 
 var ExecCommand = exec.Command
 
 var NetDial = net.Dial
 
-func EnsureAgentAndKey(sshKeyPath string) error {
-	// Expand ~ to home directory if present
-	if strings.HasPrefix(sshKeyPath, "~") {
-		homeDir, err := os.UserHomeDir()
+// windowsAgentPipe is where the Windows OpenSSH agent service listens when
+// SSH_AUTH_SOCK is not set.
+const windowsAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// PassphraseEnv holds the passphrase for encrypted keys so they can be added
+// without an interactive prompt.
+const PassphraseEnv = "PULLIO_SSH_PASSPHRASE"
+
+// askpassEnv marks a pullio process started by ssh-add as its SSH_ASKPASS
+// helper.
+const askpassEnv = "PULLIO_ASKPASS"
+
+// HandleAskpass answers ssh-add's passphrase prompt when pullio was started
+// as its SSH_ASKPASS helper. It reports whether it did, in which case the
+// caller should exit without doing anything else.
+func HandleAskpass() bool {
+	if os.Getenv(askpassEnv) == "" {
+		return false
+	}
+	
+	fmt.Println(os.Getenv(PassphraseEnv))
+	return true
+}
+
+// stdinIsTerminal reports whether a passphrase can be prompted for.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// errKeyEncrypted is returned by loadKey for an encrypted key when no
+// passphrase was provided.
+var errKeyEncrypted = errors.New("key is encrypted and no passphrase was provided")
+
+// errWrongPassphrase is returned by loadKey when the passphrase does not
+// decrypt the key. Retrying with ssh-add would only ask for it again.
+var errWrongPassphrase = errors.New("incorrect passphrase")
+
+// isPublicKey reports whether the file at path holds a public key in the
+// authorized_keys format of .pub files.
+func isPublicKey(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	
+	_, _, _, _, err = ssh.ParseAuthorizedKey(data)
+	return err == nil
+}
+
+// errNotPrivateKey is returned by loadKey for a file that holds no private
+// key in a format ssh-add would accept either.
+var errNotPrivateKey = errors.New("not a private key")
+
+// minRSABits is the shortest RSA key that is not reported as weak.
+const minRSABits = 2048
+
+// warnWeakKey warns when key, a private key or the public key of an
+// encrypted one, is of a type or size that servers no longer accept. The key
+// is still used.
+func warnWeakKey(sshKeyPath string, key any) {
+	bits := 0
+	switch k := key.(type) {
+	case *dsa.PrivateKey, *dsa.PublicKey:
+		logger.Warning("SSH key %s is a DSA key, which OpenSSH no longer supports; consider generating a new one with ssh-keygen -t ed25519", sshKeyPath)
+		return
+	case *rsa.PrivateKey:
+		bits = k.N.BitLen()
+	case *rsa.PublicKey:
+		bits = k.N.BitLen()
+	}
+	
+	if bits > 0 && bits < minRSABits {
+		logger.Warning("SSH key %s is a %d-bit RSA key, which many servers reject as too short; consider generating a new one with ssh-keygen -t ed25519", sshKeyPath, bits)
+	}
+}
+
+// loadKey parses the private key and adds it to the agent directly, without
+// running ssh-add.
+func loadKey(ag agent.Agent, sshKeyPath, passphrase string) error {
+	data, err := os.ReadFile(sshKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read key: %w", err)
+	}
+	// Every private key format ssh-add reads is PEM encoded.
+	if block, _ := pem.Decode(data); block == nil {
+		return errNotPrivateKey
+	}
+	
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		// Newer key files keep the public key readable, which is enough to
+		// tell the type and size before the passphrase is known.
+		if pub, ok := missing.PublicKey.(ssh.CryptoPublicKey); ok {
+			warnWeakKey(sshKeyPath, pub.CryptoPublicKey())
+		}
+		if passphrase == "" {
+			return errKeyEncrypted
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if errors.Is(err, x509.IncorrectPasswordError) {
+		return errWrongPassphrase
+	}
+	if err != nil {
+		return fmt.Errorf("unsupported or invalid key format: %w", err)
+	}
+	if missing == nil {
+		warnWeakKey(sshKeyPath, key)
+	}
+	
+	// Use the path as the comment so the key is recognized as loaded next time.
+	if err := ag.Add(agent.AddedKey{PrivateKey: key, Comment: sshKeyPath}); err != nil {
+		return fmt.Errorf("agent refused the key: %w", err)
+	}
+	
+	return nil
+}
+
+// EnsureAgentAndKey makes sure an SSH agent is running and that every key in
+// sshKeyPaths is loaded into it. Keys that do not exist or cannot be added
+// are skipped with a warning; it is only an error when none could be loaded.
+// With useKeychain, passphrases are taken from the macOS keychain or the
+// Secret Service keyring where available.
+func EnsureAgentAndKey(sshKeyPaths []string, useKeychain bool) error {
+	if useKeychain && !keychainSupported() {
+		logger.Debug("No supported keychain on this system, ignoring -use-keychain")
+		useKeychain = false
+	}
+	
+	var existingKeys []string
+	for _, sshKeyPath := range sshKeyPaths {
+		sshKeyPath, err := utils.ExpandPath(sshKeyPath)
 		if err != nil {
-			return fmt.Errorf("failed to get home directory: %w", err)
+			return err
+		}
+		
+		if _, err := os.Stat(sshKeyPath); os.IsNotExist(err) {
+			logger.Warning("SSH key does not exist, skipping: %s", sshKeyPath)
+			continue
 		}
-		sshKeyPath = filepath.Join(homeDir, sshKeyPath[1:])
+		if isPublicKey(sshKeyPath) {
+			msg := fmt.Sprintf("SSH key %s is a public key; point -key at the private key", sshKeyPath)
+			if private := strings.TrimSuffix(sshKeyPath, ".pub"); private != sshKeyPath {
+				if _, err := os.Stat(private); err == nil {
+					msg += ", probably " + private
+				}
+			}
+			logger.Error("%s", msg)
+			continue
+		}
+		existingKeys = append(existingKeys, sshKeyPath)
 	}
 	
-	if _, err := os.Stat(sshKeyPath); os.IsNotExist(err) {
-		return fmt.Errorf("SSH key does not exist: %s", sshKeyPath)
+	if len(existingKeys) == 0 {
+		return fmt.Errorf("none of the SSH keys exist and are private keys: %s", strings.Join(sshKeyPaths, ", "))
 	}
 	
 	authSock := os.Getenv("SSH_AUTH_SOCK")
+	if authSock == "" && runtime.GOOS == "windows" {
+		authSock = windowsAgentPipe
+	}
 	
 	// If SSH_AUTH_SOCK is not set, try to start ssh-agent
 	if authSock == "" {
@@ -47,42 +202,130 @@ func EnsureAgentAndKey(sshKeyPath string) error {
 		}
 	}
 	
-	// Connect to the SSH agent
-	conn, err := NetDial("unix", authSock)
+	// Connect to the SSH agent and check which keys are already loaded
+	conn, ag, keys, err := connectAgent(authSock)
+	if err != nil && authSock == windowsAgentPipe {
+		// The pipe only exists while the agent service is running.
+		logger.Debug("SSH agent pipe not available, attempting to start the ssh-agent service")
+		if startErr := startSSHAgent(); startErr != nil {
+			return fmt.Errorf("failed to start ssh-agent: %w", startErr)
+		}
+		conn, ag, keys, err = connectAgent(authSock)
+	} else if err != nil && runtime.GOOS != "windows" {
+		// A socket can outlive its agent, e.g. after the machine slept, so
+		// a fresh agent replaces one that does not answer.
+		logger.Warning("SSH agent is not responding, starting a new one: %v", err)
+		if startErr := startSSHAgent(); startErr != nil {
+			return fmt.Errorf("failed to start ssh-agent: %w", startErr)
+		}
+		conn, ag, keys, err = connectAgent(os.Getenv("SSH_AUTH_SOCK"))
+	}
 	if err != nil {
-		return fmt.Errorf("failed to connect to SSH agent socket at %s: %w", authSock, err)
+		return err
 	}
 	defer conn.Close()
 	
-	// Check if the key is already loaded
-	ag := agent.NewClient(conn)
-	keys, err := ag.List()
-	if err != nil {
-		return fmt.Errorf("failed to list keys from SSH agent: %w", err)
+	loaded := 0
+	for _, sshKeyPath := range existingKeys {
+		keyFilename := filepath.Base(sshKeyPath)
+		keyLoaded := false
+		
+		for _, key := range keys {
+			// Key comments often contain the filename
+			if strings.Contains(key.Comment, keyFilename) {
+				logger.Debug("SSH key %s is already loaded in agent", keyFilename)
+				keyLoaded = true
+				break
+			}
+		}
+		
+		// Add the key if it's not loaded
+		if !keyLoaded {
+			logger.Info("Adding SSH key: %s", sshKeyPath)
+			passphrase := os.Getenv(PassphraseEnv)
+			
+			if useKeychain && runtime.GOOS == "darwin" {
+				if err := addSSHKeyFromKeychain(sshKeyPath); err != nil {
+					logger.Warning("Failed to add SSH key %s from the keychain: %v", sshKeyPath, err)
+					continue
+				}
+				logger.Success("SSH key added successfully")
+				loaded++
+				continue
+			}
+			if useKeychain && passphrase == "" {
+				passphrase = keyringPassphrase(sshKeyPath)
+			}
+			
+			// Fall back to ssh-add, which can prompt for a passphrase and
+			// handles formats that cannot be parsed in-process.
+			if err := loadKey(ag, sshKeyPath, passphrase); err != nil {
+				logger.Debug("Could not add SSH key %s directly: %v", sshKeyPath, err)
+				
+				if errors.Is(err, errKeyEncrypted) && !stdinIsTerminal() {
+					logger.Warning("SSH key %s is passphrase-protected but there is no terminal to ask for it, set %s", sshKeyPath, PassphraseEnv)
+					continue
+				}
+				if errors.Is(err, errNotPrivateKey) {
+					logger.Warning("SSH key %s is not a private key, skipping", sshKeyPath)
+					continue
+				}
+				if errors.Is(err, errWrongPassphrase) {
+					logger.Warning("Failed to add SSH key %s to agent: %v", sshKeyPath, err)
+					continue
+				}
+				
+				if execErr := addSSHKey(sshKeyPath, passphrase); execErr != nil {
+					logger.Warning("Failed to add SSH key %s to agent: %v", sshKeyPath, execErr)
+					continue
+				}
+			}
+			logger.Success("SSH key added successfully")
+		}
+		loaded++
 	}
 	
-	keyFilename := filepath.Base(sshKeyPath)
-	keyLoaded := false
+	if loaded == 0 {
+		return errors.New("none of the SSH keys could be added to the agent")
+	}
 	
-	for _, key := range keys {
-		// Key comments often contain the filename
-		if strings.Contains(key.Comment, keyFilename) {
-			logger.Debug("SSH key %s is already loaded in agent", keyFilename)
-			keyLoaded = true
-			break
-		}
+	return nil
+}
+
+// agentTimeout is how long an agent may take to list its keys before it is
+// taken for gone.
+const agentTimeout = 5 * time.Second
+
+// connectAgent connects to the agent at authSock and lists the keys it holds,
+// which also tells whether it is still there to answer.
+func connectAgent(authSock string) (io.ReadWriteCloser, agent.ExtendedAgent, []*agent.Key, error) {
+	conn, err := dialAgent(authSock)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to SSH agent socket at %s: %w", authSock, err)
 	}
 	
-	// Add the key if it's not loaded
-	if !keyLoaded {
-		logger.Info("Adding SSH key: %s", sshKeyPath)
-		if err := addSSHKey(sshKeyPath); err != nil {
-			return fmt.Errorf("failed to add SSH key %s to agent: %w", sshKeyPath, err)
-		}
-		logger.Success("SSH key added successfully")
+	if c, ok := conn.(net.Conn); ok {
+		c.SetDeadline(time.Now().Add(agentTimeout))
+		defer c.SetDeadline(time.Time{})
+	}
+	ag := agent.NewClient(conn)
+	keys, err := ag.List()
+	if err != nil {
+		conn.Close()
+		return nil, nil, nil, fmt.Errorf("failed to list keys from SSH agent at %s: %w", authSock, err)
 	}
 	
-	return nil
+	return conn, ag, keys, nil
+}
+
+// dialAgent connects to the agent at authSock, which is a named pipe for the
+// Windows OpenSSH agent and a Unix socket otherwise.
+func dialAgent(authSock string) (io.ReadWriteCloser, error) {
+	if runtime.GOOS == "windows" && strings.HasPrefix(authSock, `\\.\pipe\`) {
+		return os.OpenFile(authSock, os.O_RDWR, 0)
+	}
+	
+	return NetDial("unix", authSock)
 }
 
 // startSSHAgent starts the ssh-agent process
@@ -145,7 +388,9 @@ func startSSHAgent() error {
 	return nil
 }
 
-func addSSHKey(sshKeyPath string) error {
+// addSSHKey runs ssh-add for the key. When a passphrase is given it is
+// supplied through SSH_ASKPASS instead of prompting on the terminal.
+func addSSHKey(sshKeyPath, passphrase string) error {
 	var cmd *exec.Cmd
 	
 	if runtime.GOOS == "windows" {
@@ -159,6 +404,29 @@ func addSSHKey(sshKeyPath string) error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	
+	if passphrase != "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate pullio executable for SSH_ASKPASS: %w", err)
+		}
+		
+		// ssh-add runs pullio again as the askpass helper, which prints the
+		// passphrase from its environment. It is set here since it may have
+		// come from the keyring rather than the environment.
+		cmd.Env = append(os.Environ(),
+			"SSH_ASKPASS="+executable,
+			"SSH_ASKPASS_REQUIRE=force",
+			askpassEnv+"=1",
+			PassphraseEnv+"="+passphrase,
+		)
+		// Older OpenSSH versions only use SSH_ASKPASS with a display set and
+		// no terminal attached.
+		if os.Getenv("DISPLAY") == "" {
+			cmd.Env = append(cmd.Env, "DISPLAY=:0")
+		}
+		cmd.Stdin = nil
+	}
+	
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("ssh-add command failed: %w", err)
 	}
@@ -0,0 +1,113 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned by AcquireLock when another process holds the lock.
+var ErrLocked = errors.New("another pullio run is updating this tree")
+
+// lockPollInterval is how often a waiting AcquireLock tries again.
+const lockPollInterval = 500 * time.Millisecond
+
+// Lock is an exclusive lock on a directory tree. The operating system
+// releases it when the process exits, however it ends.
+type Lock struct {
+	file *os.File
+}
+
+// LockPath returns the lock file for the tree at root in the user cache
+// directory, named after a hash of its absolute path so every way of
+// spelling it leads to the same file.
+func LockPath(root string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(cacheDir, "pullio", hex.EncodeToString(sum[:8])+".lock"), nil
+}
+
+// AcquireLock takes the lock at path. When another process holds it,
+// AcquireLock returns ErrLocked, or with wait tries again until the lock is
+// free or ctx is done.
+func AcquireLock(ctx context.Context, path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+		if !wait {
+			err := lockHolder(file)
+			file.Close()
+			return nil, err
+		}
+		
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	
+	// The PID is only informational, for telling who holds the lock.
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{file: file}, nil
+}
+
+// lockHolder returns ErrLocked along with the PID of the process that holds
+// the lock, when it wrote one.
+func lockHolder(file *os.File) error {
+	data := make([]byte, 32)
+	n, _ := file.ReadAt(data, 0)
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n]))); err == nil {
+		return fmt.Errorf("%w (pid %d)", ErrLocked, pid)
+	}
+	
+	return ErrLocked
+}
+
+// Release gives up the lock. The lock file is left in place, since removing
+// it could let two processes lock different files of the same name.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	
+	unlock(l.file)
+	return l.file.Close()
+}
@@ -0,0 +1,127 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
+)
+
+// DefaultPath returns where the results of the last run are kept by default.
+func DefaultPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	
+	return filepath.Join(cacheDir, "pullio", "last-run.json"), nil
+}
+
+// DefaultGCPath returns where the time garbage collection last ran in each
+// repository is kept.
+func DefaultGCPath() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	
+	return filepath.Join(cacheDir, "pullio", "gc.json"), nil
+}
+
+// Save writes results to path, replacing the previous run.
+func Save(path string, results []gitmanager.RepoResult) error {
+	if results == nil {
+		results = []gitmanager.RepoResult{}
+	}
+	
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode results: %w", err)
+	}
+	
+	return writeFile(path, data)
+}
+
+// writeFile replaces path with data. The file is written next to path first
+// so an interrupted write does not leave it truncated.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// FailedPaths returns the repositories that failed or were cancelled in the
+// run recorded at path.
+func FailedPaths(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no previous run recorded in %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	
+	var results []gitmanager.RepoResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	
+	var paths []string
+	for _, r := range results {
+		if !r.Success && !r.Skipped && !r.Filtered {
+			paths = append(paths, r.Path)
+		}
+	}
+	
+	return paths, nil
+}
+
+// LastGC reads the time garbage collection last ran in each repository,
+// keyed by path, from path. A missing file means it never did.
+func LastGC(path string) (map[string]time.Time, error) {
+	times := map[string]time.Time{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return times, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	
+	if err := json.Unmarshal(data, &times); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return times, nil
+}
+
+// SaveGC writes the times garbage collection last ran to path.
+func SaveGC(path string, times map[string]time.Time) error {
+	data, err := json.MarshalIndent(times, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode garbage collection times: %w", err)
+	}
+	
+	return writeFile(path, data)
+}
@@ -1,10 +1,13 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 
@@ -13,7 +16,9 @@ import (
 
 type FileSystem interface {
 	Stat(name string) (os.FileInfo, error)
-	WalkDir(root string, fn fs.WalkDirFunc) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	EvalSymlinks(path string) (string, error)
+	ReadFile(name string) ([]byte, error)
 }
 
 type RealFileSystem struct{}
@@ -22,8 +27,16 @@ func (RealFileSystem) Stat(name string) (os.FileInfo, error) {
 	return os.Stat(name)
 }
 
-func (RealFileSystem) WalkDir(root string, fn fs.WalkDirFunc) error {
-	return filepath.WalkDir(root, fn)
+func (RealFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (RealFileSystem) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+func (RealFileSystem) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
 }
 
 var filesystem FileSystem = RealFileSystem{}
@@ -32,67 +45,471 @@ func SetFileSystem(fs FileSystem) {
 	filesystem = fs
 }
 
-func FindGitDirs(root string) ([]string, error) {
+// ExpandPath replaces environment variables such as $HOME in path and then
+// a leading ~ with the user's home directory, so paths from config files
+// work like they would in a shell.
+func ExpandPath(path string) (string, error) {
+	path = os.ExpandEnv(path)
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path, nil
+	}
+	
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, path[1:]), nil
+}
+
+// DefaultSkipDirs holds the names of directories that hold caches,
+// dependencies or build output rather than repositories.
+var DefaultSkipDirs = []string{".cache", "node_modules", "vendor", "dist", "build", "target"}
+
+// ErrStartPathNotFound and ErrStartPathNotDir are returned by FindGitDirs
+// when the root cannot be searched.
+var (
+	ErrStartPathNotFound = errors.New("start path does not exist")
+	ErrStartPathNotDir   = errors.New("start path is not a directory")
+)
+
+// FindOptions controls how FindGitDirs walks the directory tree.
+type FindOptions struct {
+	// Exclude holds glob patterns for directories that are not scanned.
+	// Patterns containing a path separator are matched against the path
+	// relative to the root, others against the directory name at any depth.
+	Exclude []string
+	// SkipDirs holds names or glob patterns of directories that are not
+	// scanned at any depth, such as DefaultSkipDirs.
+	SkipDirs []string
+	// SkipHidden skips directories whose name starts with a dot.
+	SkipHidden bool
+	// MaxDepth limits how many levels below the root are searched. Zero
+	// only checks the root itself and a negative value means no limit.
+	MaxDepth int
+	// Concurrency is the number of directories read at the same time.
+	// Values below 1 read one directory at a time.
+	Concurrency int
+	// FollowSymlinks makes the search descend into symbolic links to
+	// directories. Each directory is scanned once no matter how many
+	// links lead to it, so link cycles end the search.
+	FollowSymlinks bool
+	// Nested also searches inside the repositories it finds, for others
+	// cloned within them. Submodules are left to their parent repository.
+	Nested bool
+	// Worktrees also returns the linked worktrees of the repositories
+	// found, as git worktree list shows them, even when they were created
+	// outside root.
+	Worktrees bool
+}
+
+// depth returns how many levels path is below root.
+func depth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// isExcluded reports whether the directory at path matches one of patterns.
+func isExcluded(root, path string, patterns []string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	
+	for _, pattern := range patterns {
+		target := filepath.Base(path)
+		if strings.Contains(filepath.ToSlash(pattern), "/") {
+			target = rel
+		}
+		
+		if ok, _ := filepath.Match(filepath.FromSlash(pattern), target); ok {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// isGitMarker reports whether the .git entry at path marks a repository.
+// Linked worktrees, submodules and repositories created with
+// --separate-git-dir have a .git file pointing at the real git directory
+// instead of a .git directory; a file whose git directory is gone does not
+// count.
+func isGitMarker(path string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return true
+	}
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	
+	gitDir, err := ReadGitFile(path)
+	if err != nil {
+		logger.Debug("Ignoring %s: %v", path, err)
+		return false
+	}
+	if target, err := filesystem.Stat(gitDir); err != nil || !target.IsDir() {
+		logger.Debug("Ignoring %s, it points at %s which is not a directory", path, gitDir)
+		return false
+	}
+	return true
+}
+
+// ReadGitFile returns the git directory named by the gitdir: line of the
+// .git file at path, resolved against the directory of the file.
+func ReadGitFile(path string) (string, error) {
+	content, err := filesystem.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	
+	target, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	target = strings.TrimSpace(target)
+	if !ok || target == "" {
+		return "", fmt.Errorf("%s is not a valid .git file", path)
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return filepath.Clean(target), nil
+}
+
+// isSubmodule reports whether the .git entry at path belongs to a submodule,
+// whose git directory is kept in the modules directory of its parent's.
+func isSubmodule(path string, info os.FileInfo) bool {
+	if info.IsDir() {
+		return false
+	}
+	
+	target, err := ReadGitFile(path)
+	return err == nil && strings.Contains(filepath.ToSlash(target), "/.git/modules/")
+}
+
+// FindGitDirs returns the .git entries of the repositories below root.
+// It stops early and returns the context's error when ctx is cancelled.
+func FindGitDirs(ctx context.Context, root string, opts FindOptions) ([]string, error) {
 	root, err := filepath.Abs(root)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get absolute path for %s: %w", root, err)
 	}
 	
-	logger.Debug("Searching for Git repositories in %s", root)
+	rootInfo, err := filesystem.Stat(root)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrStartPathNotFound, root)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to access start path %s: %w", root, err)
+	}
+	if !rootInfo.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrStartPathNotDir, root)
+	}
 	
-	var gitDirs []string
-	var mu sync.Mutex // Mutex to protect concurrent access to gitDirs
-	var searchErr error
+	for _, pattern := range opts.Exclude {
+		if _, err := filepath.Match(filepath.FromSlash(pattern), ""); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range opts.SkipDirs {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid skip-dir pattern %q: %w", pattern, err)
+		}
+	}
+	
+	logger.Debug("Searching for Git repositories in %s", root)
 	
 	// Check if the provided path is a Git repository itself
 	gitDir := filepath.Join(root, ".git")
 	info, err := filesystem.Stat(gitDir)
-	if err == nil && info.IsDir() {
+	rootIsRepo := err == nil && isGitMarker(gitDir, info)
+	if rootIsRepo && !opts.Nested {
 		logger.Debug("Found root directory is a Git repository: %s", root)
+		if opts.Worktrees {
+			return addWorktrees([]string{gitDir}), nil
+		}
 		return []string{gitDir}, nil
 	}
 	
-	// Walk the directory tree to find .git directories
-	err = filesystem.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	
+	// Scan the directory tree, reading several directories at once since
+	// the latency of each read dominates on network filesystems.
+	s := &scanner{
+		ctx:  ctx,
+		root: root,
+		opts: opts,
+	}
+	if opts.FollowSymlinks {
+		s.visited = make(map[string]bool)
+	}
+	s.run(concurrency)
+	
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	
+	if opts.Worktrees {
+		return addWorktrees(s.gitDirs), nil
+	}
+	
+	// Directories finish in no particular order; sort to match a
+	// sequential walk.
+	sort.Strings(s.gitDirs)
+	return s.gitDirs, nil
+}
+
+// addWorktrees adds the .git files of the linked worktrees of the
+// repositories in gitDirs that are not in it yet and sorts the result.
+func addWorktrees(gitDirs []string) []string {
+	seen := make(map[string]bool, len(gitDirs))
+	for _, gitPath := range gitDirs {
+		seen[resolvePath(gitPath)] = true
+	}
+	
+	for _, gitPath := range gitDirs {
+		for _, worktree := range linkedWorktrees(gitPath) {
+			if seen[resolvePath(worktree)] {
+				continue
+			}
+			seen[resolvePath(worktree)] = true
+			gitDirs = append(gitDirs, worktree)
+			logger.Debug("Found linked worktree: %s", filepath.Dir(worktree))
+		}
+	}
+	
+	sort.Strings(gitDirs)
+	return gitDirs
+}
+
+// linkedWorktrees returns the .git files of the linked worktrees of the
+// repository whose .git entry is at gitPath. Worktrees whose directory is
+// gone are left out.
+func linkedWorktrees(gitPath string) []string {
+	info, err := filesystem.Stat(gitPath)
+	if err != nil {
+		return nil
+	}
+	gitDir := gitPath
+	if !info.IsDir() {
+		if gitDir, err = ReadGitFile(gitPath); err != nil {
+			return nil
+		}
+	}
+	
+	// Git keeps a directory under worktrees for each linked worktree, whose
+	// gitdir file names the .git file of the worktree.
+	adminDir := filepath.Join(gitDir, "worktrees")
+	entries, err := filesystem.ReadDir(adminDir)
+	if err != nil {
+		return nil
+	}
+	
+	var worktrees []string
+	for _, entry := range entries {
+		content, err := filesystem.ReadFile(filepath.Join(adminDir, entry.Name(), "gitdir"))
 		if err != nil {
-			logger.Debug("Error accessing path %s: %v", path, err)
-			return filepath.SkipDir
+			continue
+		}
+		target := strings.TrimSpace(string(content))
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(adminDir, entry.Name(), target)
 		}
+		target = filepath.Clean(target)
 		
-		if d.IsDir() {
-			name := d.Name()
-			
-			// Skip common directories that don't contain Git repositories
-			if name == "node_modules" || name == ".git" || 
-			   strings.HasPrefix(name, ".") || 
-			   name == "vendor" || name == "dist" || 
-			   name == "build" || name == "target" {
-				return filepath.SkipDir
-			}
-			
-			gitPath := filepath.Join(path, ".git")
-			info, err := filesystem.Stat(gitPath)
-			if err == nil && info.IsDir() {
-				mu.Lock()
-				gitDirs = append(gitDirs, gitPath)
-				mu.Unlock()
-				logger.Debug("Found Git repository: %s", path)
-				
-				// Skip scanning inside this directory as it's a Git repository
-				return filepath.SkipDir
-			}
+		info, err := filesystem.Stat(target)
+		if err != nil || !isGitMarker(target, info) {
+			logger.Debug("Ignoring worktree %s, it no longer exists", filepath.Dir(target))
+			continue
 		}
+		worktrees = append(worktrees, target)
+	}
+	
+	return worktrees
+}
+
+// resolvePath returns path with symbolic links resolved, or path itself
+// when it cannot be resolved.
+func resolvePath(path string) string {
+	if resolved, err := filesystem.EvalSymlinks(path); err == nil {
+		return resolved
+	}
+	return path
+}
+
+// scanner holds the state shared by the workers of one FindGitDirs call.
+type scanner struct {
+	ctx  context.Context
+	root string
+	opts FindOptions
+	
+	mu sync.Mutex // Mutex to protect concurrent access to the fields below
+	// cond wakes up the workers waiting for directories to be queued or for
+	// the scan to end.
+	cond *sync.Cond
+	// queue holds the directories waiting to be visited; pending counts
+	// them together with the ones being visited.
+	queue   []string
+	pending int
+	gitDirs []string
+	// visited holds the resolved paths of the directories scanned so far
+	// when symbolic links are followed.
+	visited map[string]bool
+}
+
+// run scans the tree below the root with a fixed number of workers and
+// returns when every directory has been visited.
+func (s *scanner) run(workers int) {
+	s.cond = sync.NewCond(&s.mu)
+	s.queue = []string{s.root}
+	s.pending = 1
+	
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work()
+		}()
+	}
+	wg.Wait()
+}
+
+// work visits queued directories and queues their subdirectories until
+// none are left.
+func (s *scanner) work() {
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && s.pending > 0 {
+			s.cond.Wait()
+		}
+		if s.pending == 0 {
+			s.mu.Unlock()
+			return
+		}
+		path := s.queue[len(s.queue)-1]
+		s.queue = s.queue[:len(s.queue)-1]
+		s.mu.Unlock()
+		
+		subdirs := s.visit(path)
+		
+		s.mu.Lock()
+		s.queue = append(s.queue, subdirs...)
+		s.pending += len(subdirs) - 1
+		if len(subdirs) > 0 || s.pending == 0 {
+			s.cond.Broadcast()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// visit checks the directory at path and returns its subdirectories to be
+// scanned next.
+func (s *scanner) visit(path string) []string {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	
+	// The root is searched even if its name would be skipped.
+	if path != s.root && s.skipped(filepath.Base(path)) {
+		return nil
+	}
+	
+	if path != s.root && isExcluded(s.root, path, s.opts.Exclude) {
+		logger.Debug("Excluding directory: %s", path)
+		return nil
+	}
+	
+	if s.opts.FollowSymlinks && !s.firstVisit(path) {
+		logger.Debug("Already searched %s through another link", path)
+		return nil
+	}
+	
+	gitPath := filepath.Join(path, ".git")
+	info, err := filesystem.Stat(gitPath)
+	switch {
+	case err != nil || !isGitMarker(gitPath, info):
+	case s.opts.Nested && path != s.root && isSubmodule(gitPath, info):
+		logger.Debug("Leaving submodule %s to its parent repository", path)
+	default:
+		s.mu.Lock()
+		s.gitDirs = append(s.gitDirs, gitPath)
+		s.mu.Unlock()
+		logger.Debug("Found Git repository: %s", path)
 		
+		// Skip scanning inside this directory as it's a Git repository,
+		// unless nested repositories are searched for.
+		if !s.opts.Nested {
+			return nil
+		}
+	}
+	
+	if s.opts.MaxDepth >= 0 && depth(s.root, path) >= s.opts.MaxDepth {
+		logger.Debug("Reached maximum depth at %s", path)
 		return nil
-	})
+	}
 	
+	entries, err := filesystem.ReadDir(path)
 	if err != nil {
-		searchErr = fmt.Errorf("error walking directory %s: %w", root, err)
+		logger.Debug("Error accessing path %s: %v", path, err)
+		return nil
 	}
 	
-	if searchErr != nil {
-		return nil, searchErr
+	var subdirs []string
+	for _, entry := range entries {
+		entryPath := filepath.Join(path, entry.Name())
+		if entry.IsDir() || (s.opts.FollowSymlinks && entry.Type()&fs.ModeSymlink != 0 && s.isDir(entryPath)) {
+			subdirs = append(subdirs, entryPath)
+		}
+	}
+	return subdirs
+}
+
+// skipped reports whether directories with the given name are left out of
+// the search.
+func (s *scanner) skipped(name string) bool {
+	if name == ".git" || (s.opts.SkipHidden && strings.HasPrefix(name, ".")) {
+		return true
+	}
+	
+	for _, pattern := range s.opts.SkipDirs {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// isDir reports whether path, following symbolic links, is a directory.
+func (s *scanner) isDir(path string) bool {
+	info, err := filesystem.Stat(path)
+	if err != nil {
+		logger.Debug("Error following link %s: %v", path, err)
+		return false
 	}
 	
-	return gitDirs, nil
+	return info.IsDir()
+}
+
+// firstVisit records the directory that path resolves to and reports
+// whether it had not been scanned before.
+func (s *scanner) firstVisit(path string) bool {
+	resolved, err := filesystem.EvalSymlinks(path)
+	if err != nil {
+		logger.Debug("Error resolving path %s: %v", path, err)
+		return false
+	}
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.visited[resolved] {
+		return false
+	}
+	s.visited[resolved] = true
+	return true
 }
@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// MockFileSystem serves an in-memory tree as if it were mounted at /.
+type MockFileSystem struct {
+	files fstest.MapFS
+}
+
+// name turns an absolute path into the name of the entry in files.
+func (m MockFileSystem) name(path string) string {
+	name := strings.TrimPrefix(filepath.ToSlash(path), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (m MockFileSystem) Stat(name string) (os.FileInfo, error) {
+	return m.files.Stat(m.name(name))
+}
+
+func (m MockFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return m.files.ReadDir(m.name(name))
+}
+
+func (m MockFileSystem) EvalSymlinks(path string) (string, error) {
+	if _, err := m.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (m MockFileSystem) ReadFile(name string) ([]byte, error) {
+	return m.files.ReadFile(m.name(name))
+}
+
+// useMockFileSystem makes FindGitDirs search a tree holding the given empty
+// files for the rest of the test.
+func useMockFileSystem(t *testing.T, paths ...string) {
+	t.Helper()
+	
+	contents := make(map[string]string)
+	for _, path := range paths {
+		contents[path] = ""
+	}
+	useMockFiles(t, contents)
+}
+
+// useMockFiles is useMockFileSystem for files with content, keyed by path.
+func useMockFiles(t *testing.T, contents map[string]string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the mock tree uses Unix paths")
+	}
+	
+	files := fstest.MapFS{}
+	for path, content := range contents {
+		files[path] = &fstest.MapFile{Data: []byte(content)}
+	}
+	SetFileSystem(MockFileSystem{files: files})
+	t.Cleanup(func() {
+		SetFileSystem(RealFileSystem{})
+	})
+}
+
+// findRepeatedly runs FindGitDirs several times with many workers and fails
+// the test unless every run returns want.
+func findRepeatedly(t *testing.T, root string, opts FindOptions, want []string) {
+	t.Helper()
+	
+	for range 20 {
+		got, err := FindGitDirs(context.Background(), root, opts)
+		if err != nil {
+			t.Fatalf("FindGitDirs: %v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("FindGitDirs = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the home directory is not read from HOME on Windows")
+	}
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("CODE", "~/code")
+	t.Setenv("TILDE", "~")
+	t.Setenv("EMPTY", "")
+	
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "$HOME/src", want: "/home/test/src"},
+		{path: "${HOME}/src", want: "/home/test/src"},
+		{path: "~/src", want: "/home/test/src"},
+		{path: "~", want: "/home/test"},
+		// Variables are expanded first, so a ~ they hold is expanded too.
+		{path: "$CODE/pullio", want: "/home/test/code/pullio"},
+		{path: "$TILDE", want: "/home/test"},
+		{path: "$EMPTY/src", want: "/src"},
+		{path: "~other/src", want: "~other/src"},
+		{path: "src/~", want: "src/~"},
+		{path: "/srv/git", want: "/srv/git"},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ExpandPath(tt.path)
+			if err != nil || got != tt.want {
+				t.Errorf("ExpandPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindGitDirsSkipDirs(t *testing.T) {
+	useMockFileSystem(t,
+		"src/a/.git/HEAD",
+		"src/a/vendor/x/.git/HEAD",
+		"src/b/c/.git/HEAD",
+		"src/b/d/.git/HEAD",
+		"src/node_modules/e/.git/HEAD",
+		"src/tools/build/f/.git/HEAD",
+		"src/.config/g/.git/HEAD",
+		"src/h/README",
+	)
+	
+	findRepeatedly(t, "/src", FindOptions{SkipDirs: DefaultSkipDirs, MaxDepth: -1, Concurrency: 8}, []string{
+		"/src/.config/g/.git",
+		"/src/a/.git",
+		"/src/b/c/.git",
+		"/src/b/d/.git",
+	})
+	findRepeatedly(t, "/src", FindOptions{SkipDirs: DefaultSkipDirs, SkipHidden: true, MaxDepth: -1, Concurrency: 8}, []string{
+		"/src/a/.git",
+		"/src/b/c/.git",
+		"/src/b/d/.git",
+	})
+}
+
+func TestFindGitDirsRootIsRepo(t *testing.T) {
+	useMockFileSystem(t,
+		"src/.git/HEAD",
+		"src/sub/.git/HEAD",
+		"src/deps/lib/.git/HEAD",
+	)
+	
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: -1, Concurrency: 8}, []string{"/src/.git"})
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: -1, Concurrency: 8, Nested: true}, []string{
+		"/src/.git",
+		"/src/deps/lib/.git",
+		"/src/sub/.git",
+	})
+}
+
+func TestFindGitDirsMaxDepth(t *testing.T) {
+	useMockFileSystem(t,
+		"src/a/.git/HEAD",
+		"src/b/c/.git/HEAD",
+		"src/b/d/e/.git/HEAD",
+	)
+	
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: 0, Concurrency: 8}, nil)
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: 1, Concurrency: 8}, []string{"/src/a/.git"})
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: 2, Concurrency: 8}, []string{
+		"/src/a/.git",
+		"/src/b/c/.git",
+	})
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: -1, Concurrency: 8}, []string{
+		"/src/a/.git",
+		"/src/b/c/.git",
+		"/src/b/d/e/.git",
+	})
+}
+
+func TestFindGitDirsMaxDepthZeroRootIsRepo(t *testing.T) {
+	useMockFileSystem(t,
+		"src/.git/HEAD",
+		"src/sub/.git/HEAD",
+	)
+	
+	// Depth 0 checks the root itself, even when nested repositories are
+	// searched for.
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: 0, Concurrency: 8, Nested: true}, []string{"/src/.git"})
+}
+
+func TestFindGitDirsGitFile(t *testing.T) {
+	useMockFiles(t, map[string]string{
+		"src/main/.git/HEAD":               "ref: refs/heads/main\n",
+		"src/main/.git/worktrees/wt/HEAD":  "ref: refs/heads/wt\n",
+		"src/main/.git/worktrees/rel/HEAD": "ref: refs/heads/rel\n",
+		"src/wt/.git":                      "gitdir: /src/main/.git/worktrees/wt\n",
+		"src/rel/.git":                     "gitdir: ../main/.git/worktrees/rel\n",
+		"src/dangling/.git":                "gitdir: /src/gone/.git/worktrees/dangling\n",
+		"src/notdir/.git":                  "gitdir: /src/main/.git/HEAD\n",
+		"src/invalid/.git":                 "not a git file\n",
+		"src/empty/.git":                   "gitdir:\n",
+	})
+	
+	findRepeatedly(t, "/src", FindOptions{MaxDepth: -1, Concurrency: 8}, []string{
+		"/src/main/.git",
+		"/src/rel/.git",
+		"/src/wt/.git",
+	})
+}
+
+func TestReadGitFile(t *testing.T) {
+	useMockFiles(t, map[string]string{
+		"src/abs/.git":     "gitdir: /src/main/.git/worktrees/abs\n",
+		"src/rel/.git":     "gitdir: ../main/.git/worktrees/rel",
+		"src/spaces/.git":  "  gitdir:   /src/main/.git/worktrees/spaces  \n",
+		"src/invalid/.git": "not a git file\n",
+		"src/empty/.git":   "gitdir:\n",
+		"src/nothing/.git": "",
+	})
+	
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "/src/abs/.git", want: "/src/main/.git/worktrees/abs"},
+		{path: "/src/rel/.git", want: "/src/main/.git/worktrees/rel"},
+		{path: "/src/spaces/.git", want: "/src/main/.git/worktrees/spaces"},
+		{path: "/src/invalid/.git", wantErr: true},
+		{path: "/src/empty/.git", wantErr: true},
+		{path: "/src/nothing/.git", wantErr: true},
+		{path: "/src/missing/.git", wantErr: true},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ReadGitFile(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ReadGitFile(%q) = %q, want an error", tt.path, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ReadGitFile(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+			}
+		})
+	}
+}
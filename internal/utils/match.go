@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ValidateMatchPattern checks that pattern is a glob MatchRepoPath can use.
+func ValidateMatchPattern(pattern string) error {
+	for _, segment := range strings.Split(filepath.ToSlash(pattern), "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("invalid match pattern %q: %w", pattern, err)
+		}
+	}
+	
+	return nil
+}
+
+// MatchRepoPath reports whether the repository at repoPath matches pattern.
+// Like FindOptions.Exclude, a pattern without a path separator is matched
+// against the directory name, and other patterns against the path relative
+// to root, or the absolute path for absolute patterns. A ** segment matches
+// any number of directories, so **/frontend-* matches at any depth.
+func MatchRepoPath(pattern, root, repoPath string) bool {
+	pattern = filepath.ToSlash(pattern)
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, filepath.Base(repoPath))
+		return ok
+	}
+	
+	target := filepath.ToSlash(repoPath)
+	if !filepath.IsAbs(pattern) && !strings.HasPrefix(pattern, "/") {
+		// Repositories outside root, e.g. from a list, can still match
+		// patterns that start with **.
+		if rel, err := filepath.Rel(root, repoPath); err == nil && !strings.HasPrefix(rel, "..") {
+			target = filepath.ToSlash(rel)
+		}
+	}
+	
+	return matchSegments(splitPath(pattern), splitPath(target))
+}
+
+// splitPath splits a slash-separated path into its non-empty segments.
+func splitPath(p string) []string {
+	var segments []string
+	for _, segment := range strings.Split(p, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	
+	return segments
+}
+
+// matchSegments matches path segments against pattern segments, where a **
+// segment matches zero or more path segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadRepoList reads repository paths from the file at path, one per line.
+// Blank lines and lines starting with # are ignored. A leading ~ is expanded
+// and relative paths are resolved against the directory of the file.
+func ReadRepoList(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository list: %w", err)
+	}
+	defer file.Close()
+	
+	baseDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path for %s: %w", path, err)
+	}
+	
+	var repos []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		
+		if line == "~" || strings.HasPrefix(line, "~/") {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get home directory: %w", err)
+			}
+			line = filepath.Join(homeDir, line[1:])
+		} else if !filepath.IsAbs(line) {
+			line = filepath.Join(baseDir, line)
+		}
+		
+		repos = append(repos, filepath.Clean(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read repository list %s: %w", path, err)
+	}
+	
+	return repos, nil
+}
@@ -0,0 +1,739 @@
+// Package pullio finds Git repositories and updates them, as the pullio
+// command does, for use from other Go programs.
+//
+// Progress is logged the same way as on the command line. Programs that add
+// passphrase-protected SSH keys must call HandleAskpass first thing in main.
+package pullio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lyubomir-bozhinov/pullio/internal/events"
+	"github.com/lyubomir-bozhinov/pullio/internal/forge"
+	"github.com/lyubomir-bozhinov/pullio/internal/gitmanager"
+	"github.com/lyubomir-bozhinov/pullio/internal/logger"
+	"github.com/lyubomir-bozhinov/pullio/internal/report"
+	"github.com/lyubomir-bozhinov/pullio/internal/scheduler"
+	"github.com/lyubomir-bozhinov/pullio/internal/sshagent"
+	"github.com/lyubomir-bozhinov/pullio/internal/utils"
+)
+
+// RepoOptions controls how each repository is updated.
+type RepoOptions = gitmanager.Options
+
+// Result is the outcome of updating one repository.
+type Result = gitmanager.RepoResult
+
+// Reason is the category of a failed or skipped repository, see
+// Result.Reason.
+type Reason = gitmanager.Reason
+
+// Git performs the git operations on each repository, see Options.Git.
+type Git = gitmanager.Git
+
+// Results holds the outcome of a run, sorted by path. Group splits them by
+// outcome.
+type Results = report.Summary
+
+// ErrAborted is returned by Update when Options.Confirm declined the run.
+var ErrAborted = errors.New("update aborted")
+
+// ErrTooManyRepos is returned by Update, List and Find when the search
+// found more than Options.MaxDiscovered repositories.
+var ErrTooManyRepos = errors.New("too many repositories found")
+
+// Options selects the repositories to update and how.
+type Options struct {
+	// Path is the directory searched for repositories.
+	Path string
+	// Repos, when not nil, lists the repositories to update instead of
+	// searching Path. Paths that are not repositories are reported as
+	// failed.
+	Repos []string
+	// Exclude, SkipDirs, SkipHidden, MaxDepth, FollowSymlinks, Nested and
+	// Worktrees control the search as described in utils.FindOptions.
+	// MaxDepth zero only checks Path itself and a negative value means no
+	// limit.
+	Exclude        []string
+	SkipDirs       []string
+	SkipHidden     bool
+	MaxDepth       int
+	FollowSymlinks bool
+	Nested         bool
+	Worktrees      bool
+	// Match, if set, only keeps the repositories whose path matches one of
+	// the patterns, see utils.MatchRepoPath. The others are reported as
+	// filtered out.
+	Match []string
+	// MaxRepos only updates the first MaxRepos repositories found, if set.
+	MaxRepos int
+	// MaxDiscovered, if set, ends the run with ErrTooManyRepos before any
+	// repository is updated when the search finds more than this many, as
+	// a guard against searching the wrong directory.
+	MaxDiscovered int
+	// RemoteFilter, if set, leaves out repositories whose remote URL does
+	// not match.
+	RemoteFilter *regexp.Regexp
+	// Since, if set, skips repositories without commits or checkouts in
+	// this long.
+	Since time.Duration
+	
+	// Concurrency is the number of repositories updated, and directories
+	// searched, at the same time. Zero picks both automatically, see
+	// updateConcurrency and searchConcurrency.
+	Concurrency int
+	// ScanConcurrency, if set, replaces Concurrency for the search, e.g. to
+	// read one directory at a time from a slow network file system.
+	ScanConcurrency int
+	// PerHost limits how many repositories with the same remote host are
+	// updated at the same time. Zero means no limit.
+	PerHost int
+	// FailFast cancels the run as soon as a repository fails. The
+	// repositories that were not finished are reported as cancelled.
+	FailFast bool
+	
+	// SSHKeys are added to the SSH agent when a repository has an SSH
+	// remote and neither sets core.sshCommand nor gets a Repo.SSHKey.
+	// DefaultSSHKeyPath is used when there are none.
+	SSHKeys []string
+	// UseKeychain takes key passphrases from the system keychain.
+	UseKeychain bool
+	// GitConfig holds key=value settings applied to every git command.
+	GitConfig []string
+	// Remote is the name of the remote repositories are updated from.
+	// Empty means gitmanager.DefaultRemote, origin.
+	Remote string
+	
+	Repo RepoOptions
+	// Override, if set, returns the options for the repository at repoPath,
+	// given Repo.
+	Override func(repoPath string, opts RepoOptions) RepoOptions
+	// Confirm, if set, is called with the number of repositories about to
+	// be updated. Returning false ends the run with ErrAborted.
+	Confirm func(count int) bool
+	// Started and Finished, if set, are called when a repository starts
+	// being updated and when it is done. They are called from several
+	// goroutines at once.
+	Started  func(repoPath string)
+	Finished func(result Result)
+	// OpenRequests looks up the number of open pull or merge requests of
+	// each repository, see Result.OpenRequests.
+	OpenRequests bool
+	// Git performs the git operations. Nil runs the git binary.
+	Git Git
+}
+
+// DefaultOptions returns the options the pullio command uses when no flags
+// are given.
+func DefaultOptions() Options {
+	return Options{
+		Path:        ".",
+		SkipDirs:    append([]string(nil), utils.DefaultSkipDirs...),
+		MaxDepth:    -1,
+		Concurrency: 0,
+		PerHost:     4,
+		Repo: RepoOptions{
+			DefaultBranches: []string{"main", "master"},
+		},
+	}
+}
+
+// DefaultSSHKeyPath returns the key used when Options.SSHKeys is empty.
+func DefaultSSHKeyPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "~"
+	}
+	
+	return filepath.Join(homeDir, ".ssh", "id_ed25519")
+}
+
+// HandleAskpass answers ssh-add's passphrase prompt when the program was
+// started as its helper, and reports whether it did. The program should then
+// exit without doing anything else.
+func HandleAskpass() bool {
+	return sshagent.HandleAskpass()
+}
+
+// Updater updates repositories. The zero value is ready to use.
+type Updater struct {
+	// Events, if set, receives a JSON line for each step of the run, as
+	// written by the -events flag. Otherwise events go to the emitter
+	// carried by the context, if any.
+	Events io.Writer
+}
+
+// Update updates repositories with an Updater that has no event stream.
+func Update(ctx context.Context, opts Options) (Results, error) {
+	var u Updater
+	return u.Update(ctx, opts)
+}
+
+// Update finds the repositories selected by opts and updates them. The
+// error is only set when the run could not go ahead, such as for invalid
+// options, a failed search or SSH agent setup; repositories that fail are
+// reported in the results. Cancelling ctx stops the run, and the
+// repositories that were not finished are reported as cancelled.
+func (u *Updater) Update(ctx context.Context, opts Options) (Results, error) {
+	results := Results{DryRun: opts.Repo.DryRun, FetchOnly: opts.Repo.FetchOnly, ReportAhead: opts.Repo.ReportAhead, ReportFsck: opts.Repo.ReportFsck}
+	
+	switch opts.Repo.VerifyClean {
+	case "", "warn", "fail":
+	default:
+		return results, fmt.Errorf("unknown verify-clean mode %q, expected warn or fail", opts.Repo.VerifyClean)
+	}
+	if opts.Repo.Tags && opts.Repo.NoTags {
+		return results, errors.New("tags and no tags cannot both be set")
+	}
+	for _, entry := range opts.GitConfig {
+		if err := gitmanager.ValidateGitConfig(entry); err != nil {
+			return results, err
+		}
+	}
+	
+	g := opts.Git
+	if g == nil {
+		g = gitmanager.ExecGit{}
+	}
+	// go-git reads neither, so they would be silently ignored.
+	if _, ok := g.(gitmanager.GoGit); ok && (len(opts.GitConfig) > 0 || opts.Repo.CredentialHelper != "") {
+		return results, errors.New("git config and credential helpers cannot be used with the go-git backend")
+	}
+	
+	emitter := events.FromContext(ctx)
+	if u.Events != nil {
+		emitter = events.NewEmitter(u.Events)
+	}
+	ctx = gitmanager.WithGitConfig(ctx, opts.GitConfig)
+	ctx = gitmanager.WithRemote(ctx, opts.Remote)
+	ctx = gitmanager.WithSharedFetch(ctx)
+	ctx = events.WithEmitter(ctx, emitter)
+	
+	repoPaths, leftOut, err := selectRepos(ctx, g, opts)
+	if err != nil {
+		return results, err
+	}
+	if len(repoPaths) == 0 && len(leftOut) == 0 {
+		logger.Info("No Git repositories found. Exiting.")
+		return results, nil
+	}
+	
+	// Repositories left out by the filters are reported without being
+	// processed.
+	for _, result := range leftOut {
+		emitter.Emit("repo_done", result)
+	}
+	
+	if opts.Confirm != nil && len(repoPaths) > 0 && !opts.Confirm(len(repoPaths)) {
+		return results, ErrAborted
+	}
+	
+	// HTTPS remotes authenticate through git's credential helpers, so the
+	// agent is only needed for repositories reached over SSH that do not
+	// pick their own key, and not at all for a report that never contacts
+	// the remotes.
+	urls := remoteURLs(ctx, g, repoPaths)
+	repoOpts := repoOptions(repoPaths, opts)
+	if opts.Repo.ReportAhead || opts.Repo.ReportFsck {
+		logger.Debug("Only reporting, skipping SSH agent setup")
+	} else if keys := agentKeys(ctx, g, urls, repoOpts, opts.SSHKeys); len(keys) > 0 {
+		logger.Info("Initializing SSH agent...")
+		if err := sshagent.EnsureAgentAndKey(keys, opts.UseKeychain); err != nil {
+			return results, fmt.Errorf("SSH agent setup failed: %w", err)
+		}
+	} else {
+		logger.Debug("No SSH remotes need the agent, skipping SSH agent setup")
+	}
+	
+	// A failure cancels the repositories still running and keeps the others
+	// from starting, the same as an interrupt.
+	var stopOnFailure context.CancelFunc
+	if opts.FailFast {
+		ctx, stopOnFailure = context.WithCancel(ctx)
+		defer stopOnFailure()
+	}
+	
+	// Process repositories concurrently, limiting how many talk to the same
+	// host at once
+	jobs := make([]scheduler.Job, len(repoPaths))
+	for i, repoPath := range repoPaths {
+		jobs[i] = scheduler.Job{Path: repoPath, Host: gitmanager.RemoteHost(urls[repoPath])}
+	}
+	
+	resultChan := make(chan Result, len(repoPaths))
+	var notStarted []Result
+	go func() {
+		left := scheduler.Run(ctx, jobs, updateConcurrency(opts, jobs), opts.PerHost, func(job scheduler.Job) {
+			if opts.Started != nil {
+				opts.Started(job.Path)
+			}
+			result := gitmanager.ProcessRepository(ctx, g, job.Path, repoOpts[job.Path])
+			if opts.Finished != nil {
+				opts.Finished(result)
+			}
+			resultChan <- result
+		})
+		for _, job := range left {
+			notStarted = append(notStarted, Result{
+				Path:         job.Path,
+				Cancelled:    true,
+				ErrorMessage: "Cancelled",
+				Reason:       gitmanager.ReasonCancelled,
+				Err:          context.Canceled,
+			})
+		}
+		close(resultChan)
+	}()
+	
+	// Collect results
+	all := leftOut
+	for result := range resultChan {
+		all = append(all, result)
+		logger.Progress(len(all)-len(leftOut), len(repoPaths))
+		if opts.FailFast && failed(result) && ctx.Err() == nil {
+			logger.Warning("%s failed, cancelling remaining repositories", result.Path)
+			stopOnFailure()
+		}
+	}
+	all = append(all, notStarted...)
+	for _, result := range notStarted {
+		emitter.Emit("repo_done", result)
+	}
+	
+	if opts.OpenRequests {
+		addOpenRequests(ctx, all, urls, opts)
+	}
+	
+	// Results arrive in completion order; sort them so the summary is the
+	// same from run to run.
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Path < all[j].Path
+	})
+	results.Results = all
+	
+	groups := results.Group()
+	emitter.Emit("run_done", struct {
+		Succeeded int `json:"succeeded"`
+		Skipped   int `json:"skipped"`
+		Failed    int `json:"failed"`
+		Filtered  int `json:"filtered"`
+		Cancelled int `json:"cancelled"`
+	}{len(groups.Succeeded), len(groups.Skipped), len(groups.Failed), len(groups.Filtered), len(groups.Cancelled)})
+	
+	return results, nil
+}
+
+// failed reports whether result is a failure, as opposed to a success, a
+// skipped or filtered repository or one that was cancelled.
+func failed(result Result) bool {
+	return !result.Success && !result.Skipped && !result.Filtered && !result.Cancelled
+}
+
+// Repository is a repository List found.
+type Repository struct {
+	Path string `json:"path"`
+	// URL is the URL of the remote, empty when there is none.
+	URL string `json:"url,omitempty"`
+	// Branch is the checked out branch, empty when HEAD is detached.
+	Branch string `json:"branch,omitempty"`
+}
+
+// List returns the repositories Update would process with opts, without
+// touching them. Options that only affect how repositories are updated are
+// ignored.
+func List(ctx context.Context, opts Options) ([]Repository, error) {
+	g := opts.Git
+	if g == nil {
+		g = gitmanager.ExecGit{}
+	}
+	ctx = gitmanager.WithGitConfig(ctx, opts.GitConfig)
+	ctx = gitmanager.WithRemote(ctx, opts.Remote)
+	
+	repoPaths, _, err := selectRepos(ctx, g, opts)
+	if err != nil {
+		return nil, err
+	}
+	
+	repos := make([]Repository, 0, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		repo := Repository{Path: repoPath}
+		repo.URL, _ = g.RemoteURL(ctx, repoPath)
+		repo.Branch, _ = g.CurrentBranch(ctx, repoPath)
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool {
+		return repos[i].Path < repos[j].Path
+	})
+	
+	return repos, nil
+}
+
+// selectRepos finds the repositories to process, or takes opts.Repos, and
+// applies the limit and filters of opts. The repositories left out by the
+// filters are returned as results that were not processed.
+func selectRepos(ctx context.Context, g Git, opts Options) (repoPaths []string, leftOut []Result, err error) {
+	for _, pattern := range opts.Match {
+		if err := utils.ValidateMatchPattern(pattern); err != nil {
+			return nil, nil, err
+		}
+	}
+	
+	repoPaths = opts.Repos
+	if repoPaths == nil {
+		repoPaths, err = findRepos(ctx, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	repoPaths = dedupeRepos(repoPaths)
+	
+	if len(opts.Match) > 0 && len(repoPaths) > 0 {
+		var unmatched []Result
+		repoPaths, unmatched = filterByPath(repoPaths, opts)
+		if len(repoPaths) == 0 {
+			logger.Info("No repositories matched %s", strings.Join(opts.Match, ", "))
+		} else {
+			logger.Info("%d repositories match, %d filtered out", len(repoPaths), len(unmatched))
+		}
+		leftOut = append(leftOut, unmatched...)
+	}
+	
+	if opts.MaxRepos > 0 && len(repoPaths) > opts.MaxRepos {
+		logger.Info("Limiting the run to the first %d of %d repositories", opts.MaxRepos, len(repoPaths))
+		repoPaths = repoPaths[:opts.MaxRepos]
+	}
+	events.FromContext(ctx).Emit("scan_done", struct {
+		Count int `json:"count"`
+	}{len(repoPaths)})
+	
+	// Repositories that -match left out are still reported.
+	if len(repoPaths) == 0 {
+		return nil, leftOut, nil
+	}
+	
+	if opts.RemoteFilter != nil {
+		var filtered []Result
+		repoPaths, filtered = filterByRemote(ctx, g, repoPaths, opts.RemoteFilter)
+		logger.Info("%d repositories match the remote filter, %d filtered out", len(repoPaths), len(filtered))
+		leftOut = append(leftOut, filtered...)
+	}
+	if opts.Since > 0 {
+		var stale []Result
+		cutoff := time.Now().Add(-opts.Since)
+		repoPaths, stale = filterStale(ctx, g, repoPaths, cutoff)
+		logger.Info("%d repositories were active since %s, %d are stale", len(repoPaths), cutoff.Format("2006-01-02 15:04"), len(stale))
+		leftOut = append(leftOut, stale...)
+	}
+	
+	return repoPaths, leftOut, nil
+}
+
+// Find searches opts.Path for repositories as Update does, before any of the
+// filters are applied. Passing them back as Options.Repos skips the search,
+// e.g. when the same tree is updated over and over.
+func Find(ctx context.Context, opts Options) ([]string, error) {
+	return findRepos(ctx, opts)
+}
+
+// findRepos searches opts.Path, after expanding ~ and environment
+// variables, for repositories. A search that was cancelled returns the
+// context's error.
+func findRepos(ctx context.Context, opts Options) ([]string, error) {
+	root, err := utils.ExpandPath(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	
+	logger.Info("Finding Git repositories from %s...", root)
+	startTime := time.Now()
+	gitDirs, err := utils.FindGitDirs(ctx, root, utils.FindOptions{
+		Exclude:        opts.Exclude,
+		SkipDirs:       opts.SkipDirs,
+		SkipHidden:     opts.SkipHidden,
+		MaxDepth:       opts.MaxDepth,
+		Concurrency:    searchConcurrency(opts),
+		FollowSymlinks: opts.FollowSymlinks,
+		Nested:         opts.Nested,
+		Worktrees:      opts.Worktrees,
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find Git directories: %w", err)
+	}
+	logger.Success("Found %d Git repositories in %v", len(gitDirs), time.Since(startTime))
+	if opts.MaxDiscovered > 0 && len(gitDirs) > opts.MaxDiscovered {
+		return nil, fmt.Errorf("%w: %d in %s, more than the limit of %d", ErrTooManyRepos, len(gitDirs), root, opts.MaxDiscovered)
+	}
+	
+	repoPaths := make([]string, 0, len(gitDirs))
+	for _, gitDir := range gitDirs {
+		repoPaths = append(repoPaths, filepath.Dir(gitDir))
+	}
+	
+	return repoPaths, nil
+}
+
+// filterByPath splits repoPaths into the repositories whose path matches one
+// of opts.Match and results for the ones that are left out.
+func filterByPath(repoPaths []string, opts Options) (matched []string, unmatched []Result) {
+	root, err := utils.ExpandPath(opts.Path)
+	if err == nil {
+		root, err = filepath.Abs(root)
+	}
+	if err != nil {
+		root = opts.Path
+	}
+	
+	for _, repoPath := range repoPaths {
+		if matchesAny(opts.Match, root, repoPath) {
+			matched = append(matched, repoPath)
+			continue
+		}
+		
+		logger.Debug("%s does not match, skipping", repoPath)
+		unmatched = append(unmatched, Result{Path: repoPath, Filtered: true, Reason: gitmanager.ReasonUnmatched})
+	}
+	
+	return matched, unmatched
+}
+
+// matchesAny reports whether repoPath matches one of patterns.
+func matchesAny(patterns []string, root, repoPath string) bool {
+	for _, pattern := range patterns {
+		if utils.MatchRepoPath(pattern, root, repoPath) {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// filterByRemote splits repoPaths into the repositories whose remote URL
+// matches filter and results for the ones that are left out.
+func filterByRemote(ctx context.Context, g Git, repoPaths []string, filter *regexp.Regexp) (matched []string, filtered []Result) {
+	for _, repoPath := range repoPaths {
+		url, err := g.RemoteURL(ctx, repoPath)
+		if err == nil && filter.MatchString(url) {
+			matched = append(matched, repoPath)
+			continue
+		}
+		
+		logger.Debug("Origin of %s does not match the remote filter, skipping", repoPath)
+		filtered = append(filtered, Result{Path: repoPath, Filtered: true, Reason: gitmanager.ReasonFiltered})
+	}
+	
+	return matched, filtered
+}
+
+// dedupeRepos drops the paths that lead to the same worktree as an earlier
+// one, such as a directory reached through a symbolic link or a copy of a
+// .git file made with --separate-git-dir. Linked worktrees of a repository
+// are kept, each with its own branch to update, and fetch once together as
+// arranged by gitmanager.WithSharedFetch. Paths that are not repositories
+// are kept to be reported.
+func dedupeRepos(repoPaths []string) []string {
+	seen := make(map[string]string, len(repoPaths))
+	repos := make(map[string]bool, len(repoPaths))
+	unique := make([]string, 0, len(repoPaths))
+	shared := 0
+	for _, repoPath := range repoPaths {
+		gitDir, commonDir, err := gitmanager.GitDirs(repoPath)
+		if err != nil {
+			unique = append(unique, repoPath)
+			continue
+		}
+		
+		if first, ok := seen[gitDir]; ok {
+			logger.Debug("Skipping %s, it shares its git directory %s with %s", repoPath, gitDir, first)
+			continue
+		}
+		seen[gitDir] = repoPath
+		unique = append(unique, repoPath)
+		
+		if repos[commonDir] {
+			shared++
+		}
+		repos[commonDir] = true
+	}
+	
+	if dropped := len(repoPaths) - len(unique); dropped > 0 {
+		logger.Debug("Left out %d repositories that share a git directory with another", dropped)
+	}
+	if shared > 0 {
+		logger.Debug("%d worktrees share a repository with another and are not fetched again", shared)
+	}
+	return unique
+}
+
+// filterStale splits repoPaths into the repositories with activity after
+// cutoff and skipped results for the others. Repositories whose activity
+// cannot be determined are kept.
+func filterStale(ctx context.Context, g Git, repoPaths []string, cutoff time.Time) (active []string, stale []Result) {
+	for _, repoPath := range repoPaths {
+		last, err := g.LastActivity(ctx, repoPath)
+		if err != nil || last.After(cutoff) {
+			active = append(active, repoPath)
+			continue
+		}
+		
+		logger.Debug("No activity in %s since %s, skipping", repoPath, last.Format(time.DateOnly))
+		stale = append(stale, Result{
+			Path:         repoPath,
+			Skipped:      true,
+			Stale:        true,
+			ErrorMessage: "Stale",
+			Reason:       gitmanager.ReasonStale,
+		})
+	}
+	
+	return active, stale
+}
+
+// remoteURLs looks up the remote URL of each repository. Repositories
+// without the remote are left out.
+func remoteURLs(ctx context.Context, g Git, repoPaths []string) map[string]string {
+	urls := make(map[string]string, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		url, err := g.RemoteURL(ctx, repoPath)
+		if err == nil {
+			urls[repoPath] = url
+		}
+	}
+	
+	return urls
+}
+
+// repoOptions returns the options each repository is updated with: Repo,
+// after Override when it is set.
+func repoOptions(repoPaths []string, opts Options) map[string]RepoOptions {
+	repoOpts := make(map[string]RepoOptions, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		repoOpts[repoPath] = opts.Repo
+		if opts.Override != nil {
+			repoOpts[repoPath] = opts.Override(repoPath, opts.Repo)
+		}
+	}
+	return repoOpts
+}
+
+// agentKeys returns the keys to load into the SSH agent for the
+// repositories with SSH remotes. Those whose options name an SSHKey add it,
+// so its passphrase is only asked for once. Those that set core.sshCommand
+// are left to it. The others need sshKeys, or DefaultSSHKeyPath when there
+// are none.
+func agentKeys(ctx context.Context, g Git, urls map[string]string, repoOpts map[string]RepoOptions, sshKeys []string) []string {
+	needKeys := false
+	var repoKeys []string
+	for repoPath, url := range urls {
+		if !gitmanager.IsSSHURL(url) {
+			continue
+		}
+		
+		switch {
+		case repoOpts[repoPath].SSHKey != "":
+			repoKeys = append(repoKeys, repoOpts[repoPath].SSHKey)
+		case g.HasSSHCommand(ctx, repoPath):
+			logger.Debug("%s sets core.sshCommand, leaving the SSH key to it", repoPath)
+		default:
+			needKeys = true
+		}
+	}
+	
+	var keys []string
+	if needKeys {
+		keys = append(keys, sshKeys...)
+		if len(keys) == 0 {
+			keys = []string{DefaultSSHKeyPath()}
+		}
+	}
+	
+	sort.Strings(repoKeys)
+	for _, key := range repoKeys {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Bounds of the concurrency picked when Options.Concurrency is zero.
+const (
+	minAutoConcurrency = 4
+	maxAutoConcurrency = 32
+)
+
+// searchConcurrency returns how many directories are read at the same time.
+// The search is bound by the disk and the CPU, so automatically it is the
+// number of CPUs, at least minAutoConcurrency.
+func searchConcurrency(opts Options) int {
+	if opts.ScanConcurrency > 0 {
+		return opts.ScanConcurrency
+	}
+	if opts.Concurrency > 0 {
+		return opts.Concurrency
+	}
+	return max(runtime.NumCPU(), minAutoConcurrency)
+}
+
+// updateConcurrency returns how many of jobs run at the same time. Updates
+// mostly wait on the network, so automatically every distinct remote host
+// gets as many as PerHost allows, minAutoConcurrency when it does not limit
+// them. At least the number of CPUs run for local remotes, and never more
+// than maxAutoConcurrency.
+func updateConcurrency(opts Options, jobs []scheduler.Job) int {
+	if opts.Concurrency > 0 {
+		return opts.Concurrency
+	}
+	
+	perHost := opts.PerHost
+	if perHost < 1 {
+		perHost = minAutoConcurrency
+	}
+	hosts := make(map[string]bool)
+	for _, job := range jobs {
+		if job.Host != "" {
+			hosts[job.Host] = true
+		}
+	}
+	
+	limit := min(max(runtime.NumCPU(), minAutoConcurrency, len(hosts)*perHost), maxAutoConcurrency)
+	logger.Debug("Running up to %d at a time for %d remote hosts", limit, len(hosts))
+	return limit
+}
+
+// addOpenRequests looks up the number of open pull or merge requests of the
+// repositories that were processed. Repositories whose forge is unknown or
+// cannot be reached are left without a count.
+func addOpenRequests(ctx context.Context, results []Result, urls map[string]string, opts Options) {
+	index := make(map[string]int, len(results))
+	var jobs []scheduler.Job
+	for i, r := range results {
+		if r.Filtered || r.Cancelled || urls[r.Path] == "" {
+			continue
+		}
+		index[r.Path] = i
+		jobs = append(jobs, scheduler.Job{Path: r.Path, Host: gitmanager.RemoteHost(urls[r.Path])})
+	}
+	
+	scheduler.Run(ctx, jobs, updateConcurrency(opts, jobs), opts.PerHost, func(job scheduler.Job) {
+		count, err := forge.OpenRequests(ctx, urls[job.Path])
+		if err != nil {
+			logger.Debug("No open request count for %s: %v", job.Path, err)
+			return
+		}
+		results[index[job.Path]].OpenRequests = &count
+	})
+}